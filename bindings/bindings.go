@@ -7,7 +7,8 @@ import "goshua/bindings/immutable"
 
 // *bindings implements the goshua.Bindings interface.
 type bindings struct {
-	ply *immutable.Ply
+	ply    *immutable.Ply
+	policy *goshua.FloatPolicy
 }
 
 func emptyBindings() goshua.Bindings {
@@ -34,8 +35,42 @@ func (b *bindings) Get(v goshua.Variable) (interface{}, bool) {
 	return nil, false
 }
 
+func (b *bindings) Variables() []goshua.Variable {
+	variables := make(map[goshua.Variable]bool)
+	for p := b.ply; p != nil; p = p.Previous() {
+		p.GetVariables(variables)
+	}
+	result := []goshua.Variable{}
+	for v := range variables {
+		result = append(result, v)
+	}
+	return result
+}
+
+func (b *bindings) EquivalenceClass(v goshua.Variable) []goshua.Variable {
+	// Bind gives each new Ply all of the Variables that are equivalent
+	// to the ones being bound, so the most recent Ply that has v has
+	// its whole class.
+	for p := b.ply; p != nil; p = p.Previous() {
+		if p.Has(v) {
+			variables := make(map[goshua.Variable]bool)
+			p.GetVariables(variables)
+			result := []goshua.Variable{}
+			for v1 := range variables {
+				result = append(result, v1)
+			}
+			return result
+		}
+	}
+	return []goshua.Variable{v}
+}
+
 func (b *bindings) Bind(v goshua.Variable, other interface{}) (goshua.Bindings, bool) {
 	// log.Printf("Binding %s to %#v", v.Name(), other)
+	if goshua.IsAny(v) || goshua.IsAny(other) {
+		// The anonymous variable is never bound.
+		return b, true
+	}
 	variables := make(map[goshua.Variable]bool)
 	variables[v] = true
 	var hasValue bool
@@ -60,9 +95,9 @@ func (b *bindings) Bind(v goshua.Variable, other interface{}) (goshua.Bindings,
 			// This ply provides a value.  If we already have a value then it better
 			// match.
 			if hasValue {
-				eq, err := goshua.Equal(p.Value(), value)
+				eq, err := goshua.EqualIn(b, p.Value(), value)
 				if err != nil {
-					log.Printf("%s", err.Error())
+					log.Printf("%s", goshua.Explain(p.Value(), value, err).Error())
 					return b, false
 				}
 				if !eq {
@@ -83,10 +118,28 @@ func (b *bindings) Bind(v goshua.Variable, other interface{}) (goshua.Bindings,
 	      }
 	   }
 	*/
-	return &bindings{ply: immutable.NewPly(
-		variables,
-		value, hasValue,
-		b.ply)}, true
+	return &bindings{
+		ply: immutable.NewPly(
+			variables,
+			value, hasValue,
+			b.ply),
+		policy: b.policy,
+	}, true
+}
+
+// FloatPolicy implements goshua.FloatPolicyBindings.
+func (b *bindings) FloatPolicy() *goshua.FloatPolicy {
+	return b.policy
+}
+
+// WithFloatPolicy implements goshua.FloatPolicyBindings.
+func (b *bindings) WithFloatPolicy(policy *goshua.FloatPolicy) goshua.Bindings {
+	return &bindings{ply: b.ply, policy: policy}
+}
+
+// Empty implements goshua.Emptier.
+func (b *bindings) Empty() goshua.Bindings {
+	return &bindings{ply: immutable.EmptyPly(), policy: b.policy}
 }
 
 // Unify allows us to unify two sets of bindings.
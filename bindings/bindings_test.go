@@ -2,6 +2,7 @@ package bindings
 
 import "fmt"
 import "reflect"
+import "sort"
 import "testing"
 import "goshua/goshua"
 import _ "goshua/variables"
@@ -212,3 +213,139 @@ func TestUnifyFail(t *testing.T) {
 		t.Errorf("Unifiy should have failed")
 	})
 }
+
+func TestBindAnonymous(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	b0 := goshua.EmptyBindings()
+	b, ok := b0.Bind(goshua.Any, 1)
+	if !ok || b != b0 {
+		t.Errorf("binding goshua.Any should succeed without a new binding")
+	}
+	b, ok = b.Bind(v1, goshua.Any)
+	if !ok || b != b0 {
+		t.Errorf("binding to goshua.Any should succeed without a new binding")
+	}
+	if val, ok := b.Get(v1); ok {
+		t.Errorf("v1 should not have a value: %v", val)
+	}
+	if val, ok := b.Get(goshua.Any); ok {
+		t.Errorf("goshua.Any should not have a value: %v", val)
+	}
+}
+
+func variableNames(vars []goshua.Variable) []string {
+	names := []string{}
+	for _, v := range vars {
+		names = append(names, v.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestIntrospection(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	v3 := s.Lookup("v3")
+	v4 := s.Lookup("v4")
+	v5 := s.Lookup("v5")
+	v6 := s.Lookup("v6")
+
+	b := goshua.EmptyBindings()
+	bind := func(v goshua.Variable, val interface{}) {
+		var ok bool
+		if b, ok = b.Bind(v, val); !ok {
+			t.Fatalf("binding %s to %v failed", v.Name(), val)
+		}
+	}
+	bind(v1, v2)
+	bind(v3, "three")
+	bind(v2, v4)
+	bind(v4, []interface{}{v3, v6})
+	bind(v5, v6)
+
+	if got, want := variableNames(b.Variables()), []string{"v1", "v2", "v3", "v4", "v5", "v6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables: got %v, want %v", got, want)
+	}
+	if got, want := variableNames(b.EquivalenceClass(v1)), []string{"v1", "v2", "v4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EquivalenceClass(v1): got %v, want %v", got, want)
+	}
+	if got, want := variableNames(b.EquivalenceClass(s.Lookup("v7"))), []string{"v7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EquivalenceClass(v7): got %v, want %v", got, want)
+	}
+	classes := []string{}
+	for _, class := range goshua.EquivalenceClasses(b) {
+		classes = append(classes, fmt.Sprint(variableNames(class)))
+	}
+	sort.Strings(classes)
+	if got, want := classes, []string{"[v1 v2 v4]", "[v3]", "[v5 v6]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EquivalenceClasses: got %v, want %v", got, want)
+	}
+
+	p := goshua.Project(b, v1, v4, v5)
+	if got, want := variableNames(p.Variables()), []string{"v1", "v4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Project Variables: got %v, want %v", got, want)
+	}
+	if _, ok := p.Get(v3); ok {
+		t.Errorf("v3 should not be in the projection")
+	}
+	if val, ok := p.Get(v1); !ok || !reflect.DeepEqual(val, []interface{}{v3, v6}) {
+		t.Errorf("Project: v1 has value %v %v", val, ok)
+	}
+
+	m := goshua.BindingsMap(b)
+	want := map[string]interface{}{
+		"v1": []interface{}{"three", v6},
+		"v2": []interface{}{"three", v6},
+		"v3": "three",
+		"v4": []interface{}{"three", v6},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("BindingsMap: got %#v, want %#v", m, want)
+	}
+	j, err := goshua.BindingsJSON(b, v1, v3, v5)
+	if err != nil {
+		t.Fatalf("BindingsJSON: %s", err)
+	}
+	if got, want := string(j), `{"v1":["three","?v6"],"v3":"three"}`; got != want {
+		t.Errorf("BindingsJSON: got %s, want %s", got, want)
+	}
+}
+
+func TestFloatPolicy(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	tenth, fifth := 0.1, 0.2
+
+	unifies := func(b goshua.Bindings) bool {
+		b, ok := b.Bind(v1, tenth+fifth)
+		if !ok {
+			t.Errorf("binding %s failed", v1.Name())
+			return false
+		}
+		unified := false
+		goshua.Unify(v1, 0.3, b, func(b goshua.Bindings) {
+			unified = true
+		})
+		return unified
+	}
+	if unifies(goshua.EmptyBindings()) {
+		t.Errorf("%v and 0.3 should not unify exactly", tenth+fifth)
+	}
+	b := goshua.WithFloatPolicy(goshua.EmptyBindings(), goshua.FloatPolicy{Absolute: 1e-9})
+	if !unifies(b) {
+		t.Errorf("%v and 0.3 should unify with a tolerance", tenth+fifth)
+	}
+
+	// The policy is kept by Bind and used to check consistency.
+	b, _ = b.Bind(v2, 1.0)
+	b, ok := b.Bind(v2, 1.0000000001)
+	if !ok {
+		t.Errorf("binding %s to a nearly equal value failed", v2.Name())
+	}
+	if _, ok := b.Bind(v2, 1.1); ok {
+		t.Errorf("binding %s to an unequal value succeeded", v2.Name())
+	}
+}
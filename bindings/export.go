@@ -0,0 +1,86 @@
+package bindings
+
+import "encoding/json"
+import "fmt"
+import "goshua/goshua"
+
+// The functions here work with any goshua.Bindings.  Packages providing
+// other implementations import this one so that they are set.
+
+func init() {
+	goshua.EquivalenceClasses = equivalenceClasses
+	goshua.Project = project
+	goshua.BindingsMap = bindingsMap
+	goshua.BindingsJSON = bindingsJSON
+	goshua.WithFloatPolicy = withFloatPolicy
+}
+
+func withFloatPolicy(b goshua.Bindings, policy goshua.FloatPolicy) goshua.Bindings {
+	fb, ok := b.(goshua.FloatPolicyBindings)
+	if !ok {
+		panic(fmt.Sprintf("%T can't carry a FloatPolicy", b))
+	}
+	return fb.WithFloatPolicy(&policy)
+}
+
+func equivalenceClasses(b goshua.Bindings) [][]goshua.Variable {
+	done := make(map[goshua.Variable]bool)
+	classes := [][]goshua.Variable{}
+	for _, v := range b.Variables() {
+		if done[v] {
+			continue
+		}
+		class := b.EquivalenceClass(v)
+		for _, v1 := range class {
+			done[v1] = true
+		}
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+func project(b goshua.Bindings, variables ...goshua.Variable) goshua.Bindings {
+	var projected goshua.Bindings
+	if e, ok := b.(goshua.Emptier); ok {
+		projected = e.Empty()
+	} else {
+		projected = goshua.EmptyBindings()
+	}
+	wanted := make(map[goshua.Variable]bool)
+	for _, v := range variables {
+		wanted[v] = true
+	}
+	for _, v := range variables {
+		if value, ok := b.Get(v); ok {
+			projected, _ = projected.Bind(v, value)
+		}
+		for _, v1 := range b.EquivalenceClass(v) {
+			if v1 != v && wanted[v1] {
+				projected, _ = projected.Bind(v, v1)
+			}
+		}
+	}
+	return projected
+}
+
+func bindingsMap(b goshua.Bindings, variables ...goshua.Variable) map[string]interface{} {
+	if len(variables) == 0 {
+		variables = b.Variables()
+	}
+	m := make(map[string]interface{})
+	for _, v := range variables {
+		value, ok := b.Get(v)
+		if !ok {
+			continue
+		}
+		if goshua.Resolve != nil {
+			value, _ = goshua.Resolve(value, b)
+		}
+		m[v.Name()] = value
+	}
+	return m
+}
+
+func bindingsJSON(b goshua.Bindings, variables ...goshua.Variable) ([]byte, error) {
+	return json.Marshal(bindingsMap(b, variables...))
+}
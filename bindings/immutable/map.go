@@ -0,0 +1,215 @@
+package immutable
+
+import "math/bits"
+import "reflect"
+import "goshua/goshua"
+
+// Map is a persistent map from Variables to values implemented as a hash
+// array mapped trie.  Set returns a new Map which shares structure with
+// the receiver.  The receiver is unchanged.
+type Map struct {
+	root  *mapNode
+	count int
+}
+
+const mapBitsPerLevel = 5
+const mapLevelMask = (1 << mapBitsPerLevel) - 1
+
+// mapEntry is a leaf of the trie.
+type mapEntry struct {
+	hash  uint32
+	key   goshua.Variable
+	value interface{}
+}
+
+// mapNode is an interior node of the trie.  children holds a
+// *mapEntry, *mapNode or *mapCollision for each bit that is set in
+// bitmap.
+type mapNode struct {
+	bitmap   uint32
+	children []interface{}
+}
+
+// mapCollision holds entries whose keys have the same hash.
+type mapCollision struct {
+	hash    uint32
+	entries []*mapEntry
+}
+
+var emptyMap = &Map{root: &mapNode{}}
+
+// EmptyMap returns a Map with no entries.
+func EmptyMap() *Map {
+	return emptyMap
+}
+
+// hashVariable computes the FNV-1a hash of the Variable's name and the
+// address of its Scope, so that variables with the same name in
+// different Scopes, like those of different rule instances, are spread
+// out.  Any remaining collisions are told apart by mapCollision.
+func hashVariable(v goshua.Variable) uint32 {
+	h := uint32(2166136261)
+	name := v.Name()
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	if s := reflect.ValueOf(v.Scope()); s.Kind() == reflect.Ptr {
+		p := uint64(s.Pointer())
+		for i := 0; i < 8; i++ {
+			h ^= uint32(p & 0xff)
+			h *= 16777619
+			p >>= 8
+		}
+	}
+	return h
+}
+
+// Len returns the number of entries in the Map.
+func (m *Map) Len() int {
+	return m.count
+}
+
+// Get returns the value associated with key.
+func (m *Map) Get(key goshua.Variable) (interface{}, bool) {
+	hash := hashVariable(key)
+	n := m.root
+	for shift := uint(0); ; shift += mapBitsPerLevel {
+		bit := uint32(1) << ((hash >> shift) & mapLevelMask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		switch c := n.children[n.index(bit)].(type) {
+		case *mapEntry:
+			if c.key == key {
+				return c.value, true
+			}
+			return nil, false
+		case *mapCollision:
+			for _, e := range c.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return nil, false
+		case *mapNode:
+			n = c
+		}
+	}
+}
+
+// Set returns a new Map in which key is associated with value.
+func (m *Map) Set(key goshua.Variable, value interface{}) *Map {
+	e := &mapEntry{
+		hash:  hashVariable(key),
+		key:   key,
+		value: value,
+	}
+	root, added := m.root.set(0, e)
+	count := m.count
+	if added {
+		count++
+	}
+	return &Map{root: root, count: count}
+}
+
+// Do calls f on each entry of the Map in no particular order.
+func (m *Map) Do(f func(key goshua.Variable, value interface{})) {
+	m.root.do(f)
+}
+
+// index returns the position in n.children of the child for bit.
+func (n *mapNode) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// with returns a copy of n in which the child for bit is replaced by or,
+// if n has no such child, extended with child.
+func (n *mapNode) with(bit uint32, child interface{}) *mapNode {
+	i := n.index(bit)
+	n1 := &mapNode{bitmap: n.bitmap | bit}
+	if n.bitmap&bit != 0 {
+		n1.children = make([]interface{}, len(n.children))
+		copy(n1.children, n.children)
+	} else {
+		n1.children = make([]interface{}, len(n.children)+1)
+		copy(n1.children, n.children[:i])
+		copy(n1.children[i+1:], n.children[i:])
+	}
+	n1.children[i] = child
+	return n1
+}
+
+// set returns a copy of n which includes e and whether e's key was new.
+func (n *mapNode) set(shift uint, e *mapEntry) (*mapNode, bool) {
+	bit := uint32(1) << ((e.hash >> shift) & mapLevelMask)
+	if n.bitmap&bit == 0 {
+		return n.with(bit, e), true
+	}
+	switch c := n.children[n.index(bit)].(type) {
+	case *mapEntry:
+		if c.key == e.key {
+			return n.with(bit, e), false
+		}
+		if c.hash == e.hash {
+			return n.with(bit, &mapCollision{
+				hash:    e.hash,
+				entries: []*mapEntry{c, e},
+			}), true
+		}
+		return n.with(bit, mapPair(shift+mapBitsPerLevel, c, c.hash, e)), true
+	case *mapCollision:
+		if c.hash != e.hash {
+			return n.with(bit, mapPair(shift+mapBitsPerLevel, c, c.hash, e)), true
+		}
+		entries := make([]*mapEntry, len(c.entries), len(c.entries)+1)
+		copy(entries, c.entries)
+		for i, old := range entries {
+			if old.key == e.key {
+				entries[i] = e
+				return n.with(bit, &mapCollision{hash: c.hash, entries: entries}), false
+			}
+		}
+		entries = append(entries, e)
+		return n.with(bit, &mapCollision{hash: c.hash, entries: entries}), true
+	case *mapNode:
+		child, added := c.set(shift+mapBitsPerLevel, e)
+		return n.with(bit, child), added
+	}
+	panic("immutable.Map: bad child")
+}
+
+// mapPair returns a node containing child, whose hash is h, and e.
+// The hashes must differ.
+func mapPair(shift uint, child interface{}, h uint32, e *mapEntry) *mapNode {
+	bit1 := uint32(1) << ((h >> shift) & mapLevelMask)
+	bit2 := uint32(1) << ((e.hash >> shift) & mapLevelMask)
+	if bit1 == bit2 {
+		return &mapNode{
+			bitmap:   bit1,
+			children: []interface{}{mapPair(shift+mapBitsPerLevel, child, h, e)},
+		}
+	}
+	n := &mapNode{bitmap: bit1 | bit2}
+	if bit1 < bit2 {
+		n.children = []interface{}{child, e}
+	} else {
+		n.children = []interface{}{e, child}
+	}
+	return n
+}
+
+func (n *mapNode) do(f func(goshua.Variable, interface{})) {
+	for _, child := range n.children {
+		switch c := child.(type) {
+		case *mapEntry:
+			f(c.key, c.value)
+		case *mapCollision:
+			for _, e := range c.entries {
+				f(e.key, e.value)
+			}
+		case *mapNode:
+			c.do(f)
+		}
+	}
+}
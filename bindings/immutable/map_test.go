@@ -0,0 +1,72 @@
+package immutable
+
+import "fmt"
+import "testing"
+import "goshua/goshua"
+
+// TestMap sets 2000 Variables, enough for several levels of the trie, and
+// checks Len, Get and Do, that Set leaves earlier Maps unchanged and that
+// Set replaces the value of a key that is already there.
+func TestMap(t *testing.T) {
+	s1 := goshua.NewScope()
+	s2 := goshua.NewScope()
+	m := EmptyMap()
+	var vars []goshua.Variable
+	for i := 0; i < 1000; i++ {
+		// The same names in two scopes are different keys.
+		name := fmt.Sprintf("v%d", i)
+		vars = append(vars, s1.Lookup(name), s2.Lookup(name))
+	}
+	maps := []*Map{m}
+	for i, v := range vars {
+		m = m.Set(v, i)
+		maps = append(maps, m)
+	}
+	if m.Len() != len(vars) {
+		t.Errorf("Len: want %d, got %d", len(vars), m.Len())
+	}
+	for i, v := range vars {
+		if val, ok := m.Get(v); !ok || val != i {
+			t.Errorf("Get(%s): want %d, got %v %v", v, i, val, ok)
+		}
+		// Earlier maps are unchanged.
+		if val, ok := maps[i].Get(v); ok {
+			t.Errorf("Map %d should not have %s: %v", i, v, val)
+		}
+		if val, ok := maps[i+1].Get(v); !ok || val != i {
+			t.Errorf("Map %d Get(%s): want %d, got %v %v", i+1, v, i, val, ok)
+		}
+	}
+	// Replace a value.
+	m2 := m.Set(vars[7], "seven")
+	if m2.Len() != m.Len() {
+		t.Errorf("Replacing a value changed Len to %d", m2.Len())
+	}
+	if val, _ := m2.Get(vars[7]); val != "seven" {
+		t.Errorf("replaced value: got %v", val)
+	}
+	if val, _ := m.Get(vars[7]); val != 7 {
+		t.Errorf("original value: got %v", val)
+	}
+	count := 0
+	m.Do(func(v goshua.Variable, val interface{}) {
+		count++
+		if vars[val.(int)] != v {
+			t.Errorf("Do: %s has wrong value %v", v, val)
+		}
+	})
+	if count != len(vars) {
+		t.Errorf("Do visited %d entries, want %d", count, len(vars))
+	}
+}
+
+// The same name in different Scopes should mostly hash differently.
+func TestHashVariableScopes(t *testing.T) {
+	hashes := map[uint32]bool{}
+	for i := 0; i < 100; i++ {
+		hashes[hashVariable(goshua.NewScope().Lookup("x"))] = true
+	}
+	if len(hashes) < 90 {
+		t.Errorf("?x in 100 scopes has only %d different hashes", len(hashes))
+	}
+}
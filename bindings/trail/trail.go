@@ -0,0 +1,322 @@
+// Package trail provides an implementation of the goshua.Bindings
+// interface which keeps its bindings in a mutable store and records each
+// change on an undo trail, as the Warren Abstract Machine does.
+//
+// Bind changes the store in place, pushes the change onto the trail and
+// returns a *bindings which records the length of the trail.  Using a
+// *bindings whose changes are all still on the trail first undoes the
+// changes made after it.  This is backtracking: the *bindings made after
+// it are invalid from then on, and using one of them panics.  The trail
+// and the store are reused as the search goes back and forth, so once they
+// have grown Bind only allocates the *bindings it returns.
+//
+// This suits depth first search, where a choice point is only returned to
+// once everything tried after it is finished with.  Programs that keep
+// Bindings and go back to them out of order should use goshua/bindings.
+//
+// A store must not be used by more than one goroutine at a time.
+//
+// Link this package in instead of goshua/bindings to use it.
+package trail
+
+import "fmt"
+import "log"
+import "goshua/goshua"
+import _ "goshua/bindings"
+
+// cell holds what the store knows about a Variable.  Variables that are
+// bound to one another form union-find trees.  Only the root of a tree
+// has a value.
+type cell struct {
+	parent goshua.Variable
+	value  interface{}
+	// rank bounds the height of the tree rooted at this Variable.
+	rank     int32
+	hasValue bool
+}
+
+// entry records a single change to the store so that it can be undone.
+type entry struct {
+	variable goshua.Variable
+	before   cell
+	// existed is false if variable had no cell before the change.
+	existed bool
+	// serial tells apart entries that have had the same position on
+	// the trail.
+	serial uint64
+}
+
+type store struct {
+	cells  map[goshua.Variable]cell
+	trail  []entry
+	serial uint64
+}
+
+// *bindings implements the goshua.Bindings and goshua.Trail interfaces.
+// It is the state of its store when the trail had length mark and the
+// entry below mark had the specified serial.
+type bindings struct {
+	store  *store
+	mark   int
+	serial uint64
+	policy *goshua.FloatPolicy
+}
+
+// choicePoint is the goshua.ChoicePoint of a *bindings.
+type choicePoint struct {
+	mark   int
+	serial uint64
+}
+
+func emptyBindings() goshua.Bindings {
+	return &bindings{store: &store{cells: make(map[goshua.Variable]cell)}}
+}
+
+func init() {
+	goshua.EmptyBindings = emptyBindings
+}
+
+// valid returns true if the changes up to mark with the specified serial
+// are still on the trail.
+func (s *store) valid(mark int, serial uint64) bool {
+	return mark == 0 || mark <= len(s.trail) && s.trail[mark-1].serial == serial
+}
+
+// undo pops the trail back to mark, undoing each change.
+func (s *store) undo(mark int) {
+	for i := len(s.trail) - 1; i >= mark; i-- {
+		e := &s.trail[i]
+		if e.existed {
+			s.cells[e.variable] = e.before
+		} else {
+			delete(s.cells, e.variable)
+		}
+		// Let the garbage collector have what e refers to.
+		*e = entry{}
+	}
+	s.trail = s.trail[:mark]
+}
+
+// set changes the cell of v and records the change on the trail.
+func (s *store) set(v goshua.Variable, c cell) {
+	before, existed := s.cells[v]
+	s.serial++
+	s.trail = append(s.trail, entry{
+		variable: v,
+		before:   before,
+		existed:  existed,
+		serial:   s.serial,
+	})
+	s.cells[v] = c
+}
+
+// use makes the store hold the state that b represents.
+func (b *bindings) use() {
+	s := b.store
+	if b.mark == len(s.trail) && s.valid(b.mark, b.serial) {
+		return
+	}
+	if !s.valid(b.mark, b.serial) {
+		panic(fmt.Sprintf("trail: Bindings used after the store was undone past them (mark %d, trail length %d)",
+			b.mark, len(s.trail)))
+	}
+	s.undo(b.mark)
+}
+
+// find returns the root of v's tree and its cell.  The store must
+// already hold the right state.
+func (s *store) find(v goshua.Variable) (goshua.Variable, cell) {
+	for {
+		c, ok := s.cells[v]
+		if !ok || c.parent == nil {
+			return v, c
+		}
+		v = c.parent
+	}
+}
+
+// Mark is part of the goshua.Trail interface.
+func (b *bindings) Mark() goshua.ChoicePoint {
+	b.use()
+	return choicePoint{mark: b.mark, serial: b.serial}
+}
+
+// Undo is part of the goshua.Trail interface.
+func (b *bindings) Undo(cp goshua.ChoicePoint) {
+	c := cp.(choicePoint)
+	if !b.store.valid(c.mark, c.serial) {
+		panic(fmt.Sprintf("trail: Undo to a choice point that was already undone (mark %d, trail length %d)",
+			c.mark, len(b.store.trail)))
+	}
+	b.store.undo(c.mark)
+}
+
+func (b *bindings) Dump() {
+	b.use()
+	log.Printf("trail.Dump mark %d", b.mark)
+	for v, c := range b.store.cells {
+		if c.parent != nil {
+			log.Printf("  %s -> %s", v.Name(), c.parent.Name())
+		} else {
+			log.Printf("  %s: %v %#v", v.Name(), c.hasValue, c.value)
+		}
+	}
+}
+
+func (b *bindings) Get(v goshua.Variable) (interface{}, bool) {
+	b.use()
+	_, c := b.store.find(v)
+	if c.hasValue {
+		return c.value, true
+	}
+	return nil, false
+}
+
+func (b *bindings) Variables() []goshua.Variable {
+	b.use()
+	result := []goshua.Variable{}
+	for v := range b.store.cells {
+		result = append(result, v)
+	}
+	return result
+}
+
+func (b *bindings) EquivalenceClass(v goshua.Variable) []goshua.Variable {
+	b.use()
+	root, _ := b.store.find(v)
+	result := []goshua.Variable{}
+	for v1 := range b.store.cells {
+		if r, _ := b.store.find(v1); r == root {
+			result = append(result, v1)
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, v)
+	}
+	return result
+}
+
+// top returns the *bindings for the state the store holds now.
+func (b *bindings) top() *bindings {
+	s := b.store
+	return &bindings{
+		store:  s,
+		mark:   len(s.trail),
+		serial: s.serial,
+		policy: b.policy,
+	}
+}
+
+func (b *bindings) Bind(v goshua.Variable, other interface{}) (goshua.Bindings, bool) {
+	if goshua.IsAny(v) || goshua.IsAny(other) {
+		// The anonymous variable is never bound.
+		return b, true
+	}
+	b.use()
+	s := b.store
+	root1, c1 := s.find(v)
+	v2, ok := other.(goshua.Variable)
+	if !ok {
+		if c1.hasValue {
+			// The existing value had better match.
+			return b, b.consistent(c1.value, other)
+		}
+		c1.value = other
+		c1.hasValue = true
+		s.set(root1, c1)
+		return b.top(), true
+	}
+	root2, c2 := s.find(v2)
+	if root1 == root2 {
+		return b, true
+	}
+	value, hasValue := c1.value, c1.hasValue
+	if c2.hasValue {
+		if hasValue && !b.consistent(value, c2.value) {
+			return b, false
+		}
+		value, hasValue = c2.value, true
+	}
+	// Union by rank: the shallower tree goes under the deeper one.
+	if c1.rank > c2.rank {
+		root1, root2 = root2, root1
+		c1, c2 = c2, c1
+	}
+	rank := c2.rank
+	if c1.rank == c2.rank {
+		rank++
+	}
+	s.set(root1, cell{parent: root2})
+	s.set(root2, cell{rank: rank, value: value, hasValue: hasValue})
+	return b.top(), true
+}
+
+// consistent returns true if the two values of an equivalence class are
+// equal.
+func (b *bindings) consistent(value1, value2 interface{}) bool {
+	eq, err := goshua.EqualIn(b, value1, value2)
+	if err != nil {
+		log.Printf("%s", goshua.Explain(value1, value2, err).Error())
+		return false
+	}
+	return eq
+}
+
+// FloatPolicy implements goshua.FloatPolicyBindings.
+func (b *bindings) FloatPolicy() *goshua.FloatPolicy {
+	return b.policy
+}
+
+// WithFloatPolicy implements goshua.FloatPolicyBindings.  The result
+// represents the same state of the store as b.
+func (b *bindings) WithFloatPolicy(policy *goshua.FloatPolicy) goshua.Bindings {
+	n := *b
+	n.policy = policy
+	return &n
+}
+
+// Empty implements goshua.Emptier.  The result has a store of its own.
+func (b *bindings) Empty() goshua.Bindings {
+	e := emptyBindings().(*bindings)
+	e.policy = b.policy
+	return e
+}
+
+// fact is what the store says about one Variable.
+type fact struct {
+	variable goshua.Variable
+	cell     cell
+}
+
+// facts returns a copy of what the store says at b.
+func (b *bindings) facts() []fact {
+	b.use()
+	facts := make([]fact, 0, len(b.store.cells))
+	for v, c := range b.store.cells {
+		facts = append(facts, fact{variable: v, cell: c})
+	}
+	return facts
+}
+
+// Unify allows us to unify two sets of bindings.
+func (b1 *bindings) Unify(item interface{}, b3 goshua.Bindings, continuation func(goshua.Bindings)) {
+	b2, ok := item.(*bindings)
+	if !ok {
+		return
+	}
+	// The three Bindings might share a store, so collect what b1 and b2
+	// say before binding anything.  b3 must still be valid afterwards.
+	facts := append(b1.facts(), b2.facts()...)
+	merged := b3
+	for _, f := range facts {
+		if f.cell.parent != nil {
+			merged, ok = merged.Bind(f.variable, f.cell.parent)
+		} else if f.cell.hasValue {
+			merged, ok = merged.Bind(f.variable, f.cell.value)
+		}
+		if !ok {
+			return
+		}
+	}
+	continuation(merged)
+}
@@ -0,0 +1,235 @@
+package trail
+
+import "fmt"
+import "math/rand"
+import "testing"
+import "goshua/goshua"
+import _ "goshua/bindings"
+import _ "goshua/variables"
+import _ "goshua/equality"
+import _ "goshua/unification"
+
+// Package level variables are initialized after the packages they import
+// but before this package's init functions run, so this is the Ply based
+// implementation from goshua/bindings.
+var plyBindings = goshua.EmptyBindings
+
+func TestEmptyBindings(t *testing.T) {
+	if _, ok := goshua.EmptyBindings().(goshua.Trail); !ok {
+		t.Fatalf("goshua.EmptyBindings is not from trail")
+	}
+}
+
+// TestSameAsPly checks that a random depth first search, which binds
+// variables and backtracks to earlier Bindings, gives the same results as
+// the Ply based implementation.
+func TestSameAsPly(t *testing.T) {
+	r := rand.New(rand.NewSource(32))
+	s := goshua.NewScope()
+	var vars []goshua.Variable
+	for i := 0; i < 8; i++ {
+		vars = append(vars, s.Lookup(fmt.Sprintf("v%d", i)))
+	}
+	for trial := 0; trial < 100; trial++ {
+		// The Bindings from the empty ones to the current ones.
+		plies := []goshua.Bindings{plyBindings()}
+		trails := []goshua.Bindings{goshua.EmptyBindings()}
+		for step := 0; step < 30; step++ {
+			if len(plies) > 1 && r.Intn(3) == 0 {
+				// Backtrack.
+				n := 1 + r.Intn(len(plies)-1)
+				plies = plies[:n]
+				trails = trails[:n]
+			}
+			top := len(plies) - 1
+			v := vars[r.Intn(len(vars))]
+			var other interface{}
+			if r.Intn(2) == 0 {
+				other = vars[r.Intn(len(vars))]
+			} else {
+				other = r.Intn(3)
+			}
+			b1, ok1 := plies[top].Bind(v, other)
+			b2, ok2 := trails[top].Bind(v, other)
+			if ok1 != ok2 {
+				t.Fatalf("trial %d step %d: Bind(%s, %v) returned %v, Ply returned %v",
+					trial, step, v, other, ok2, ok1)
+			}
+			if ok1 {
+				plies = append(plies, b1)
+				trails = append(trails, b2)
+			}
+			top = len(plies) - 1
+			for _, v := range vars {
+				val1, has1 := plies[top].Get(v)
+				val2, has2 := trails[top].Get(v)
+				if has1 != has2 || val1 != val2 {
+					t.Fatalf("trial %d step %d: Get(%s) returned %v %v, Ply returned %v %v",
+						trial, step, v, val2, has2, val1, has1)
+				}
+			}
+		}
+	}
+}
+
+func TestMarkUndo(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	b0 := goshua.EmptyBindings().(goshua.Trail)
+	cp := b0.Mark()
+	b1, _ := b0.Bind(v1, v2)
+	b2, _ := b1.Bind(v2, 2)
+	st := b0.(*bindings).store
+	if len(st.cells) != 2 || len(st.trail) != 3 {
+		t.Errorf("store should have 2 cells and 3 changes, not %v %v", st.cells, st.trail)
+	}
+	if val, ok := b2.Get(v1); !ok || val != 2 {
+		t.Errorf("v1 should be 2, not %v %v", val, ok)
+	}
+	cp2 := b2.(goshua.Trail).Mark()
+	b0.Undo(cp)
+	if len(st.cells) != 0 || len(st.trail) != 0 {
+		t.Errorf("Undo should have emptied the store: %v %v", st.cells, st.trail)
+	}
+	if _, ok := b0.Get(v1); ok {
+		t.Errorf("v1 should not have a value in b0")
+	}
+	// The Bindings made after the choice point are gone.
+	for _, b := range []goshua.Bindings{b1, b2} {
+		if !panics(func() { b.Get(v1) }) {
+			t.Errorf("using Bindings that were undone should panic")
+		}
+	}
+	// Even once the trail is as long again.
+	b3, _ := b0.Bind(v1, v2)
+	if !panics(func() { b1.Get(v1) }) {
+		t.Errorf("using Bindings that were undone should panic")
+	}
+	if !panics(func() { b0.Undo(cp2) }) {
+		t.Errorf("Undo to a choice point that was undone should panic")
+	}
+	if _, ok := b3.Get(v1); ok {
+		t.Errorf("v1 should not have a value in b3")
+	}
+}
+
+func panics(f func()) (result bool) {
+	defer func() {
+		if recover() != nil {
+			result = true
+		}
+	}()
+	f()
+	return false
+}
+
+// Using earlier Bindings backtracks to them.
+func TestBacktrack(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	b := goshua.EmptyBindings()
+	st := b.(*bindings).store
+	count := 0
+	for _, fact := range [][]interface{}{{1, 2}, {3, 4}, {5, 6}} {
+		goshua.Unify([]interface{}{v1, v2}, fact, b, func(b1 goshua.Bindings) {
+			count++
+			if len(st.cells) != 2 {
+				t.Errorf("store should have 2 cells during the continuation: %v", st.cells)
+			}
+			if val, ok := b1.Get(v2); !ok || val != fact[1] {
+				t.Errorf("v2 should be %v, not %v %v", fact[1], val, ok)
+			}
+		})
+	}
+	if count != 3 {
+		t.Errorf("continuation called %d times", count)
+	}
+	if len(st.trail) != 2 {
+		t.Errorf("the trail should only hold the last match: %v", st.trail)
+	}
+	if _, ok := b.Get(v1); ok || len(st.trail) != 0 {
+		t.Errorf("using b should undo the last match")
+	}
+}
+
+// A failed unification undoes what it bound before failing.
+func TestUnifyFailureUndoes(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	b0 := goshua.EmptyBindings()
+	b1, _ := b0.Bind(v1, 1)
+	st := b0.(*bindings).store
+	goshua.Unify([]interface{}{v2, v1}, []interface{}{3, 4}, b1, func(goshua.Bindings) {
+		t.Errorf("the unification should fail")
+	})
+	if len(st.trail) != 1 || len(st.cells) != 1 {
+		t.Errorf("the failed unification should leave only v1 bound: %v %v", st.cells, st.trail)
+	}
+	// b1 is still current, so using it undoes nothing.
+	if val, ok := b1.Get(v1); !ok || val != 1 {
+		t.Errorf("v1 should be 1, not %v %v", val, ok)
+	}
+	var b2 goshua.Bindings
+	goshua.Unify([]interface{}{v2, v1}, []interface{}{3, 1}, b1, func(b goshua.Bindings) {
+		b2 = b
+	})
+	// A successful unification leaves its bindings in place.
+	if val, ok := b2.Get(v2); !ok || val != 3 {
+		t.Errorf("v2 should be 3, not %v %v", val, ok)
+	}
+}
+
+// benchmarkChain links n variables into one equivalence class, binds its
+// value and then looks up every variable.
+func benchmarkChain(b *testing.B, empty func() goshua.Bindings, n int) {
+	s := goshua.NewScope()
+	var vars []goshua.Variable
+	for i := 0; i < n; i++ {
+		vars = append(vars, s.Lookup(fmt.Sprintf("v%d", i)))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs := empty()
+		for j := 1; j < n; j++ {
+			bs, _ = bs.Bind(vars[j-1], vars[j])
+		}
+		bs, _ = bs.Bind(vars[0], n)
+		for _, v := range vars {
+			bs.Get(v)
+		}
+	}
+}
+
+// benchmarkSearch unifies a pattern against each of n facts, as a query
+// against a fact base would.
+func benchmarkSearch(b *testing.B, empty func() goshua.Bindings, n int) {
+	s := goshua.NewScope()
+	pattern := []interface{}{s.Lookup("a"), s.Lookup("b"), "x", s.Lookup("c")}
+	var facts [][]interface{}
+	for i := 0; i < n; i++ {
+		// Half of the facts fail after a and b have been bound.
+		facts = append(facts, []interface{}{i, i * 2, []string{"x", "y"}[i%2], i % 5})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs := empty()
+		count := 0
+		for _, fact := range facts {
+			goshua.Unify(pattern, fact, bs, func(goshua.Bindings) {
+				count++
+			})
+		}
+	}
+}
+
+func BenchmarkChainPly10(b *testing.B)      { benchmarkChain(b, plyBindings, 10) }
+func BenchmarkChainTrail10(b *testing.B)    { benchmarkChain(b, goshua.EmptyBindings, 10) }
+func BenchmarkChainPly100(b *testing.B)     { benchmarkChain(b, plyBindings, 100) }
+func BenchmarkChainTrail100(b *testing.B)   { benchmarkChain(b, goshua.EmptyBindings, 100) }
+func BenchmarkSearchPly1000(b *testing.B)   { benchmarkSearch(b, plyBindings, 1000) }
+func BenchmarkSearchTrail1000(b *testing.B) { benchmarkSearch(b, goshua.EmptyBindings, 1000) }
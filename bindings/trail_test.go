@@ -0,0 +1,8 @@
+//go:build trail
+// +build trail
+
+// go test -tags trail runs the tests of this package against
+// goshua/bindings/trail instead of the Ply based implementation.
+package bindings_test
+
+import _ "goshua/bindings/trail"
@@ -0,0 +1,206 @@
+// Package unionfind provides an implementation of the goshua.Bindings
+// interface in which variables that are bound to one another form
+// union-find equivalence classes stored in an immutable.Map.
+//
+// Lookup and Bind take time logarithmic in the number of bound variables
+// rather than linear in the number of Bind calls, as they do for the Ply
+// chain used by goshua/bindings.  Link this package in instead of
+// goshua/bindings to use it.
+package unionfind
+
+import "log"
+import "goshua/goshua"
+import _ "goshua/bindings"
+import "goshua/bindings/immutable"
+
+// *bindings implements the goshua.Bindings interface.
+// entries maps each bound Variable to its *entry.
+type bindings struct {
+	entries *immutable.Map
+	policy  *goshua.FloatPolicy
+}
+
+// entry records either the parent of a Variable in its equivalence class
+// or, for the root of the class, the class's value.
+type entry struct {
+	parent goshua.Variable
+	// rank bounds the height of the tree rooted at this Variable.
+	rank     int
+	value    interface{}
+	hasValue bool
+}
+
+func emptyBindings() goshua.Bindings {
+	return &bindings{entries: immutable.EmptyMap()}
+}
+
+func init() {
+	goshua.EmptyBindings = emptyBindings
+}
+
+// find returns the root of v's equivalence class and its entry.
+func (b *bindings) find(v goshua.Variable) (goshua.Variable, *entry) {
+	for {
+		e, ok := b.entries.Get(v)
+		if !ok {
+			return v, &entry{}
+		}
+		if e.(*entry).parent == nil {
+			return v, e.(*entry)
+		}
+		v = e.(*entry).parent
+	}
+}
+
+func (b *bindings) Dump() {
+	log.Printf("unionfind.Dump")
+	b.entries.Do(func(v goshua.Variable, e interface{}) {
+		e1 := e.(*entry)
+		if e1.parent != nil {
+			log.Printf("  %s -> %s", v.Name(), e1.parent.Name())
+		} else {
+			log.Printf("  %s: %v %#v", v.Name(), e1.hasValue, e1.value)
+		}
+	})
+}
+
+func (b *bindings) Get(v goshua.Variable) (interface{}, bool) {
+	_, e := b.find(v)
+	if e.hasValue {
+		return e.value, true
+	}
+	return nil, false
+}
+
+func (b *bindings) Variables() []goshua.Variable {
+	result := []goshua.Variable{}
+	b.entries.Do(func(v goshua.Variable, e interface{}) {
+		result = append(result, v)
+	})
+	return result
+}
+
+func (b *bindings) EquivalenceClass(v goshua.Variable) []goshua.Variable {
+	root, _ := b.find(v)
+	result := []goshua.Variable{}
+	b.entries.Do(func(v1 goshua.Variable, e interface{}) {
+		if r, _ := b.find(v1); r == root {
+			result = append(result, v1)
+		}
+	})
+	if len(result) == 0 {
+		result = append(result, v)
+	}
+	return result
+}
+
+func (b *bindings) Bind(v goshua.Variable, other interface{}) (goshua.Bindings, bool) {
+	if goshua.IsAny(v) || goshua.IsAny(other) {
+		// The anonymous variable is never bound.
+		return b, true
+	}
+	root1, e1 := b.find(v)
+	v2, ok := other.(goshua.Variable)
+	if !ok {
+		if e1.hasValue {
+			// The existing value had better match.
+			if !b.consistent(e1.value, other) {
+				return b, false
+			}
+			return b, true
+		}
+		return &bindings{
+			entries: b.entries.Set(root1, &entry{
+				rank:     e1.rank,
+				value:    other,
+				hasValue: true,
+			}),
+			policy: b.policy,
+		}, true
+	}
+	root2, e2 := b.find(v2)
+	if root1 == root2 {
+		return b, true
+	}
+	value, hasValue := e1.value, e1.hasValue
+	if e2.hasValue {
+		if hasValue && !b.consistent(value, e2.value) {
+			return b, false
+		}
+		value, hasValue = e2.value, true
+	}
+	// Union by rank: the shallower tree goes under the deeper one.
+	if e1.rank > e2.rank {
+		root1, root2 = root2, root1
+		e1, e2 = e2, e1
+	}
+	rank := e2.rank
+	if e1.rank == e2.rank {
+		rank++
+	}
+	entries := b.entries.Set(root1, &entry{parent: root2})
+	entries = entries.Set(root2, &entry{
+		rank:     rank,
+		value:    value,
+		hasValue: hasValue,
+	})
+	return &bindings{entries: entries, policy: b.policy}, true
+}
+
+// consistent returns true if the two values of an equivalence class are
+// equal.
+func (b *bindings) consistent(value1, value2 interface{}) bool {
+	eq, err := goshua.EqualIn(b, value1, value2)
+	if err != nil {
+		log.Printf("%s", goshua.Explain(value1, value2, err).Error())
+		return false
+	}
+	return eq
+}
+
+// FloatPolicy implements goshua.FloatPolicyBindings.
+func (b *bindings) FloatPolicy() *goshua.FloatPolicy {
+	return b.policy
+}
+
+// WithFloatPolicy implements goshua.FloatPolicyBindings.
+func (b *bindings) WithFloatPolicy(policy *goshua.FloatPolicy) goshua.Bindings {
+	return &bindings{entries: b.entries, policy: policy}
+}
+
+// Empty implements goshua.Emptier.
+func (b *bindings) Empty() goshua.Bindings {
+	return &bindings{entries: immutable.EmptyMap(), policy: b.policy}
+}
+
+// Unify allows us to unify two sets of bindings.
+func (b1 *bindings) Unify(item interface{}, b3 goshua.Bindings, continuation func(goshua.Bindings)) {
+	b2, ok := item.(*bindings)
+	if !ok {
+		return
+	}
+	merged := b3
+	// Try to merge bindings from b1 and b2 into b3.  Give up if there's a contradiction.
+	mergeFrom := func(b *bindings) bool {
+		ok := true
+		b.entries.Do(func(v goshua.Variable, e interface{}) {
+			if !ok {
+				return
+			}
+			e1 := e.(*entry)
+			if e1.parent != nil {
+				merged, ok = merged.Bind(v, e1.parent)
+			} else if e1.hasValue {
+				merged, ok = merged.Bind(v, e1.value)
+			}
+		})
+		return ok
+	}
+	if !mergeFrom(b1) {
+		return
+	}
+	if !mergeFrom(b2) {
+		return
+	}
+	continuation(merged)
+}
@@ -0,0 +1,193 @@
+package unionfind
+
+import "fmt"
+import "math/rand"
+import "reflect"
+import "sort"
+import "strings"
+import "testing"
+import "goshua/goshua"
+import _ "goshua/bindings"
+import _ "goshua/variables"
+import _ "goshua/equality"
+import _ "goshua/unification"
+
+// Package level variables are initialized after the packages they import
+// but before this package's init functions run, so this is the Ply based
+// implementation from goshua/bindings.
+var plyBindings = goshua.EmptyBindings
+
+func TestEmptyBindings(t *testing.T) {
+	if _, ok := goshua.EmptyBindings().(*bindings); !ok {
+		t.Fatalf("goshua.EmptyBindings is not from unionfind")
+	}
+	if _, ok := plyBindings().(*bindings); ok {
+		t.Fatalf("plyBindings is from unionfind")
+	}
+}
+
+func classNames(vars []goshua.Variable) string {
+	names := []string{}
+	for _, v := range vars {
+		names = append(names, v.Name())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// TestSameAsPly checks that random sequences of Bind calls give the same
+// results as the Ply based implementation.
+func TestSameAsPly(t *testing.T) {
+	r := rand.New(rand.NewSource(29))
+	s := goshua.NewScope()
+	var vars []goshua.Variable
+	for i := 0; i < 8; i++ {
+		vars = append(vars, s.Lookup(fmt.Sprintf("v%d", i)))
+	}
+	for trial := 0; trial < 200; trial++ {
+		b1 := plyBindings()
+		b2 := goshua.EmptyBindings()
+		for step := 0; step < 12; step++ {
+			v := vars[r.Intn(len(vars))]
+			var other interface{}
+			if r.Intn(2) == 0 {
+				other = vars[r.Intn(len(vars))]
+			} else {
+				other = r.Intn(3)
+			}
+			b1a, ok1 := b1.Bind(v, other)
+			b2a, ok2 := b2.Bind(v, other)
+			if ok1 != ok2 {
+				t.Fatalf("trial %d step %d: Bind(%s, %v) returned %v, Ply returned %v",
+					trial, step, v, other, ok2, ok1)
+			}
+			if !ok1 {
+				continue
+			}
+			b1, b2 = b1a, b2a
+			for _, v := range vars {
+				val1, has1 := b1.Get(v)
+				val2, has2 := b2.Get(v)
+				if has1 != has2 || val1 != val2 {
+					t.Fatalf("trial %d step %d: Get(%s) returned %v %v, Ply returned %v %v",
+						trial, step, v, val2, has2, val1, has1)
+				}
+				class1 := classNames(b1.EquivalenceClass(v))
+				class2 := classNames(b2.EquivalenceClass(v))
+				if class1 != class2 {
+					t.Fatalf("trial %d step %d: EquivalenceClass(%s) returned %s, Ply returned %s",
+						trial, step, v, class2, class1)
+				}
+			}
+		}
+	}
+}
+
+func TestUnify(t *testing.T) {
+	s := goshua.NewScope()
+	v0 := s.Lookup("v0")
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	v3 := s.Lookup("v3")
+	bind := func(b goshua.Bindings, v goshua.Variable, val interface{}) goshua.Bindings {
+		b, ok := b.Bind(v, val)
+		if !ok {
+			t.Fatalf("binding %s to %v failed", v.Name(), val)
+		}
+		return b
+	}
+	b1 := bind(goshua.EmptyBindings(), v1, v2)
+	b2 := bind(bind(goshua.EmptyBindings(), v3, 3), v2, v3)
+	var unified goshua.Bindings
+	goshua.Unify(b1, b2, bind(goshua.EmptyBindings(), v0, 0), func(b goshua.Bindings) {
+		unified = b
+	})
+	if unified == nil {
+		t.Fatalf("Unify failed")
+	}
+	for v, want := range map[goshua.Variable]interface{}{v0: 0, v1: 3, v2: 3, v3: 3} {
+		if val, ok := unified.Get(v); !ok || val != want {
+			t.Errorf("%s: want %v, got %v %v", v, want, val, ok)
+		}
+	}
+	goshua.Unify(b1, b2, bind(goshua.EmptyBindings(), v1, 1), func(b goshua.Bindings) {
+		t.Errorf("Unify should have failed")
+	})
+}
+
+// Project makes Bindings of the same kind, with the same FloatPolicy.
+func TestProject(t *testing.T) {
+	s := goshua.NewScope()
+	v1 := s.Lookup("v1")
+	v2 := s.Lookup("v2")
+	policy := goshua.FloatPolicy{Absolute: 0.01}
+	for _, empty := range []func() goshua.Bindings{plyBindings, goshua.EmptyBindings} {
+		b := goshua.WithFloatPolicy(empty(), policy)
+		b, _ = b.Bind(v1, 1.0)
+		b, _ = b.Bind(v2, 2)
+		p := goshua.Project(b, v1)
+		if reflect.TypeOf(p) != reflect.TypeOf(b) {
+			t.Errorf("Project of a %T made a %T", b, p)
+		}
+		if fp, ok := p.(goshua.FloatPolicyBindings); !ok || fp.FloatPolicy() == nil || *fp.FloatPolicy() != policy {
+			t.Errorf("Project of a %T lost its FloatPolicy", b)
+		}
+		if _, ok := p.Bind(v1, 1.001); !ok {
+			t.Errorf("Project of a %T doesn't compare with its FloatPolicy", b)
+		}
+		if _, ok := p.Get(v2); ok {
+			t.Errorf("Project of a %T kept %s", b, v2)
+		}
+	}
+}
+
+// benchmarkChain links n variables into one equivalence class, binds its
+// value and then looks up every variable.
+func benchmarkChain(b *testing.B, empty func() goshua.Bindings, n int) {
+	s := goshua.NewScope()
+	var vars []goshua.Variable
+	for i := 0; i < n; i++ {
+		vars = append(vars, s.Lookup(fmt.Sprintf("v%d", i)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs := empty()
+		for j := 1; j < n; j++ {
+			bs, _ = bs.Bind(vars[j-1], vars[j])
+		}
+		bs, _ = bs.Bind(vars[0], n)
+		for _, v := range vars {
+			bs.Get(v)
+		}
+	}
+}
+
+// benchmarkDistinct binds n variables to values and then looks up every
+// variable.
+func benchmarkDistinct(b *testing.B, empty func() goshua.Bindings, n int) {
+	s := goshua.NewScope()
+	var vars []goshua.Variable
+	for i := 0; i < n; i++ {
+		vars = append(vars, s.Lookup(fmt.Sprintf("v%d", i)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs := empty()
+		for j, v := range vars {
+			bs, _ = bs.Bind(v, j)
+		}
+		for _, v := range vars {
+			bs.Get(v)
+		}
+	}
+}
+
+func BenchmarkChainPly10(b *testing.B)        { benchmarkChain(b, plyBindings, 10) }
+func BenchmarkChainUnionFind10(b *testing.B)  { benchmarkChain(b, goshua.EmptyBindings, 10) }
+func BenchmarkChainPly100(b *testing.B)       { benchmarkChain(b, plyBindings, 100) }
+func BenchmarkChainUnionFind100(b *testing.B) { benchmarkChain(b, goshua.EmptyBindings, 100) }
+
+func BenchmarkDistinctPly100(b *testing.B)        { benchmarkDistinct(b, plyBindings, 100) }
+func BenchmarkDistinctUnionFind100(b *testing.B)  { benchmarkDistinct(b, goshua.EmptyBindings, 100) }
+func BenchmarkDistinctPly1000(b *testing.B)       { benchmarkDistinct(b, plyBindings, 1000) }
+func BenchmarkDistinctUnionFind1000(b *testing.B) { benchmarkDistinct(b, goshua.EmptyBindings, 1000) }
@@ -0,0 +1,8 @@
+//go:build unionfind
+// +build unionfind
+
+// go test -tags unionfind runs the tests of this package against
+// goshua/bindings/unionfind instead of the Ply based implementation.
+package bindings_test
+
+import _ "goshua/bindings/unionfind"
@@ -0,0 +1,549 @@
+// Package codec converts Bindings, Variables, Scopes and the terms they
+// refer to to and from plain data structures that can be encoded with
+// encoding/json or encoding/gob, for example to hand a partial query
+// state from one process to another.
+//
+// Variables are identified by the ID of their Scope and their name.
+// They are decoded in the Scope that a Scopes has for that ID, so a
+// decoded Variable is the same as the original one if its Scope was added
+// to the Scopes, and otherwise belongs to a Scope that goshua.NamedScope
+// made for the ID.
+//
+// Values are encoded by the name under which their type was registered
+// with RegisterType.  Scalar types, []interface{} and
+// map[string]interface{} are registered already.
+package codec
+
+import "encoding/gob"
+import "encoding/json"
+import "fmt"
+import "io"
+import "reflect"
+import "sort"
+import "strconv"
+import "goshua/goshua"
+
+// Variable is the encoded form of a goshua.Variable.
+type Variable struct {
+	// Scope is the ID of the Variable's Scope.  It is empty for
+	// goshua.Any.
+	Scope string `json:",omitempty"`
+	Name  string
+}
+
+// Scope is the encoded form of a goshua.Scope.
+type Scope struct {
+	ID string
+	// Names lists names to look up in the Scope when it is decoded.
+	Names []string `json:",omitempty"`
+}
+
+// Term is the encoded form of a term.
+type Term struct {
+	// Variable is set if the term is a Variable.
+	Variable *Variable `json:",omitempty"`
+	// Type is the registered name of the term's type.  It is empty for
+	// nil and Variables.
+	Type string `json:",omitempty"`
+	// Nil is set for a nil slice, map or pointer.  It distinguishes
+	// them from empty ones, which encode no Elements or Fields either.
+	Nil bool `json:",omitempty"`
+	// Scalar is the text of a bool, number or string value.
+	Scalar string `json:",omitempty"`
+	// Elements holds the elements of a slice or array, or the value
+	// pointed to.
+	Elements []*Term `json:",omitempty"`
+	// Fields holds the values of a map or of the fields of a struct.
+	Fields map[string]*Term `json:",omitempty"`
+}
+
+// Class is the encoded form of an equivalence class of Variables.
+type Class struct {
+	Variables []Variable
+	Value     *Term `json:",omitempty"`
+}
+
+// Bindings is the encoded form of a goshua.Bindings.
+type Bindings struct {
+	Classes []Class
+}
+
+// Scopes maps Scope IDs to the Scopes that Variables are decoded in.  It
+// belongs to its caller, so the Scopes it holds can be garbage collected
+// along with it.  A Scopes can't be shared between goroutines.
+type Scopes struct {
+	index map[string]goshua.Scope
+}
+
+// NewScopes returns a Scopes which holds scopes.
+func NewScopes(scopes ...goshua.Scope) (*Scopes, error) {
+	ss := &Scopes{index: make(map[string]goshua.Scope)}
+	for _, s := range scopes {
+		if err := ss.Add(s); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// Add adds s to ss, so that Variables with the ID of s are decoded in s.
+func (ss *Scopes) Add(s goshua.Scope) error {
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	if old, ok := ss.index[id]; ok && old != s {
+		return fmt.Errorf("codec: another Scope has ID %s", id)
+	}
+	ss.index[id] = s
+	return nil
+}
+
+// Scope returns the Scope with the specified ID, making it with
+// goshua.NamedScope if ss doesn't have one yet.
+func (ss *Scopes) Scope(id string) goshua.Scope {
+	s, ok := ss.index[id]
+	if !ok {
+		s = goshua.NamedScope(id)
+		ss.index[id] = s
+	}
+	return s
+}
+
+// typesByName and namesByType make up the type registry.
+var typesByName = make(map[string]reflect.Type)
+var namesByType = make(map[reflect.Type]string)
+
+// RegisterType registers t under name so that values of type t can be
+// encoded.  Registering a struct type also registers the pointer to it
+// as "*" followed by name.
+func RegisterType(name string, t reflect.Type) {
+	if old, ok := typesByName[name]; ok && old != t {
+		panic(fmt.Sprintf("codec.RegisterType: %s is already registered for %v", name, old))
+	}
+	typesByName[name] = t
+	namesByType[t] = name
+	if t.Kind() == reflect.Struct {
+		RegisterType("*"+name, reflect.PtrTo(t))
+	}
+}
+
+func init() {
+	for _, v := range []interface{}{
+		false, "",
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+		[]interface{}{}, map[string]interface{}{},
+	} {
+		t := reflect.TypeOf(v)
+		RegisterType(t.String(), t)
+	}
+}
+
+// EncodeVariable returns the encoded form of v.
+func EncodeVariable(v goshua.Variable) (Variable, error) {
+	if goshua.IsAny(v) {
+		return Variable{Name: v.Name()}, nil
+	}
+	id, err := v.Scope().ID()
+	if err != nil {
+		return Variable{}, err
+	}
+	return Variable{Scope: id, Name: v.Name()}, nil
+}
+
+// Decode returns the goshua.Variable that v encodes.
+func (v Variable) Decode(scopes *Scopes) goshua.Variable {
+	if v.Scope == "" {
+		return goshua.Any
+	}
+	return scopes.Scope(v.Scope).Lookup(v.Name)
+}
+
+// EncodeScope returns the encoded form of s.  names are included so
+// that they are looked up when the Scope is decoded.
+func EncodeScope(s goshua.Scope, names ...string) (Scope, error) {
+	id, err := s.ID()
+	if err != nil {
+		return Scope{}, err
+	}
+	return Scope{ID: id, Names: names}, nil
+}
+
+// Decode returns the goshua.Scope that s encodes.
+func (s Scope) Decode(scopes *Scopes) goshua.Scope {
+	scope := scopes.Scope(s.ID)
+	for _, name := range s.Names {
+		scope.Lookup(name)
+	}
+	return scope
+}
+
+// encoder holds the state of EncodeTerm.
+type encoder struct {
+	// visiting holds the pointers, maps and slices that are being
+	// encoded, to catch cycles.
+	visiting map[visit]bool
+}
+
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// EncodeTerm returns the encoded form of term.  An error is returned if
+// term contains a value whose type is not registered, a struct with
+// unexported fields or a cycle.
+func EncodeTerm(term interface{}) (*Term, error) {
+	e := &encoder{visiting: make(map[visit]bool)}
+	return e.encode(term)
+}
+
+// enter notes that v, a pointer, map or slice, is being encoded.  An
+// error is returned if it already is, because v contains itself.
+func (e *encoder) enter(v reflect.Value) error {
+	key := visit{v.Pointer(), v.Type()}
+	if e.visiting[key] {
+		return fmt.Errorf("codec: can't encode cyclic %v", v.Type())
+	}
+	e.visiting[key] = true
+	return nil
+}
+
+func (e *encoder) leave(v reflect.Value) {
+	delete(e.visiting, visit{v.Pointer(), v.Type()})
+}
+
+func (e *encoder) encode(term interface{}) (*Term, error) {
+	if term == nil {
+		return &Term{}, nil
+	}
+	if v, ok := term.(goshua.Variable); ok {
+		ev, err := EncodeVariable(v)
+		if err != nil {
+			return nil, err
+		}
+		return &Term{Variable: &ev}, nil
+	}
+	val := reflect.ValueOf(term)
+	name, ok := namesByType[val.Type()]
+	if !ok {
+		return nil, fmt.Errorf("codec: type %v is not registered", val.Type())
+	}
+	t := &Term{Type: name}
+	switch val.Kind() {
+	case reflect.Bool:
+		t.Scalar = strconv.FormatBool(val.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		t.Scalar = strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		t.Scalar = strconv.FormatUint(val.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		t.Scalar = strconv.FormatFloat(val.Float(), 'g', -1, val.Type().Bits())
+	case reflect.Complex64, reflect.Complex128:
+		t.Scalar = strconv.FormatComplex(val.Complex(), 'g', -1, val.Type().Bits())
+	case reflect.String:
+		t.Scalar = val.String()
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice {
+			if val.IsNil() {
+				t.Nil = true
+				break
+			}
+			if val.Len() > 0 {
+				if err := e.enter(val); err != nil {
+					return nil, err
+				}
+				defer e.leave(val)
+			}
+		}
+		for i := 0; i < val.Len(); i++ {
+			if err := e.addElement(t, val.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Ptr:
+		if val.IsNil() {
+			t.Nil = true
+			break
+		}
+		if err := e.enter(val); err != nil {
+			return nil, err
+		}
+		defer e.leave(val)
+		if err := e.addElement(t, val.Elem()); err != nil {
+			return nil, err
+		}
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("codec: map type %v doesn't have string keys", val.Type())
+		}
+		if val.IsNil() {
+			t.Nil = true
+			break
+		}
+		if err := e.enter(val); err != nil {
+			return nil, err
+		}
+		defer e.leave(val)
+		t.Fields = make(map[string]*Term)
+		iter := val.MapRange()
+		for iter.Next() {
+			if err := e.addField(t, iter.Key().String(), iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		t.Fields = make(map[string]*Term)
+		st := val.Type()
+		for i := 0; i < st.NumField(); i++ {
+			if st.Field(i).PkgPath != "" {
+				return nil, fmt.Errorf("codec: %v has unexported field %s",
+					st, st.Field(i).Name)
+			}
+			if err := e.addField(t, st.Field(i).Name, val.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("codec: can't encode %v", val.Type())
+	}
+	return t, nil
+}
+
+func (e *encoder) addElement(t *Term, v reflect.Value) error {
+	et, err := e.encode(v.Interface())
+	if err != nil {
+		return err
+	}
+	t.Elements = append(t.Elements, et)
+	return nil
+}
+
+func (e *encoder) addField(t *Term, name string, v reflect.Value) error {
+	ft, err := e.encode(v.Interface())
+	if err != nil {
+		return err
+	}
+	t.Fields[name] = ft
+	return nil
+}
+
+// Decode returns the term that t encodes.  Its Variables are decoded in
+// scopes.  A nil t, such as a null element in JSON, is an error.
+func (t *Term) Decode(scopes *Scopes) (interface{}, error) {
+	if t == nil {
+		return nil, fmt.Errorf("codec: missing term")
+	}
+	if t.Variable != nil {
+		return t.Variable.Decode(scopes), nil
+	}
+	if t.Type == "" {
+		return nil, nil
+	}
+	typ, ok := typesByName[t.Type]
+	if !ok {
+		return nil, fmt.Errorf("codec: type %s is not registered", t.Type)
+	}
+	val := reflect.New(typ).Elem()
+	var err error
+	switch typ.Kind() {
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(t.Scalar)
+		val.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(t.Scalar, 10, typ.Bits())
+		val.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(t.Scalar, 10, typ.Bits())
+		val.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(t.Scalar, typ.Bits())
+		val.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		var c complex128
+		c, err = strconv.ParseComplex(t.Scalar, typ.Bits())
+		val.SetComplex(c)
+	case reflect.String:
+		val.SetString(t.Scalar)
+	case reflect.Slice:
+		if t.Nil {
+			break
+		}
+		val.Set(reflect.MakeSlice(typ, len(t.Elements), len(t.Elements)))
+		err = t.decodeElements(val, scopes)
+	case reflect.Array:
+		if len(t.Elements) != typ.Len() {
+			return nil, fmt.Errorf("codec: %d elements for %v", len(t.Elements), typ)
+		}
+		err = t.decodeElements(val, scopes)
+	case reflect.Ptr:
+		if t.Nil {
+			break
+		}
+		if len(t.Elements) != 1 {
+			return nil, fmt.Errorf("codec: %d elements for %v", len(t.Elements), typ)
+		}
+		val.Set(reflect.New(typ.Elem()))
+		err = decodeInto(t.Elements[0], val.Elem(), scopes)
+	case reflect.Map:
+		if t.Nil {
+			break
+		}
+		val.Set(reflect.MakeMapWithSize(typ, len(t.Fields)))
+		for _, name := range sortedKeys(t.Fields) {
+			elt := reflect.New(typ.Elem()).Elem()
+			if err = decodeInto(t.Fields[name], elt, scopes); err != nil {
+				break
+			}
+			val.SetMapIndex(reflect.ValueOf(name).Convert(typ.Key()), elt)
+		}
+	case reflect.Struct:
+		for _, name := range sortedKeys(t.Fields) {
+			field := val.FieldByName(name)
+			if !field.IsValid() || !field.CanSet() {
+				return nil, fmt.Errorf("codec: %v has no exported field %s", typ, name)
+			}
+			if err = decodeInto(t.Fields[name], field, scopes); err != nil {
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("codec: can't decode %v", typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("codec: decoding %s: %s", t.Type, err)
+	}
+	return val.Interface(), nil
+}
+
+func (t *Term) decodeElements(val reflect.Value, scopes *Scopes) error {
+	for i, et := range t.Elements {
+		if err := decodeInto(et, val.Index(i), scopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeInto decodes t and stores the result in to.
+func decodeInto(t *Term, to reflect.Value, scopes *Scopes) error {
+	v, err := t.Decode(scopes)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		to.Set(reflect.Zero(to.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.Type().AssignableTo(to.Type()) {
+		return fmt.Errorf("can't store %v in %v", rv.Type(), to.Type())
+	}
+	to.Set(rv)
+	return nil
+}
+
+func sortedKeys(m map[string]*Term) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EncodeBindings returns the encoded form of b.
+func EncodeBindings(b goshua.Bindings) (*Bindings, error) {
+	eb := &Bindings{Classes: []Class{}}
+	for _, class := range goshua.EquivalenceClasses(b) {
+		c := Class{}
+		for _, v := range class {
+			ev, err := EncodeVariable(v)
+			if err != nil {
+				return nil, err
+			}
+			c.Variables = append(c.Variables, ev)
+		}
+		if value, ok := b.Get(class[0]); ok {
+			t, err := EncodeTerm(value)
+			if err != nil {
+				return nil, err
+			}
+			c.Value = t
+		}
+		eb.Classes = append(eb.Classes, c)
+	}
+	return eb, nil
+}
+
+// Decode returns a new goshua.Bindings with the bindings that eb encodes.
+// Its Variables are decoded in scopes.
+func (eb *Bindings) Decode(scopes *Scopes) (goshua.Bindings, error) {
+	b := goshua.EmptyBindings()
+	for _, c := range eb.Classes {
+		if len(c.Variables) == 0 {
+			return nil, fmt.Errorf("codec: class with no variables")
+		}
+		first := c.Variables[0].Decode(scopes)
+		var ok bool
+		for _, v := range c.Variables[1:] {
+			if b, ok = b.Bind(first, v.Decode(scopes)); !ok {
+				return nil, fmt.Errorf("codec: can't bind %s to %s", first, v.Name)
+			}
+		}
+		if c.Value != nil {
+			value, err := c.Value.Decode(scopes)
+			if err != nil {
+				return nil, err
+			}
+			if b, ok = b.Bind(first, value); !ok {
+				return nil, fmt.Errorf("codec: can't bind %s to %v", first, value)
+			}
+		}
+	}
+	return b, nil
+}
+
+// MarshalJSON returns the JSON encoding of b.
+func MarshalJSON(b goshua.Bindings) ([]byte, error) {
+	eb, err := EncodeBindings(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(eb)
+}
+
+// UnmarshalJSON returns the Bindings whose JSON encoding is data.  Their
+// Variables are decoded in scopes.
+func UnmarshalJSON(data []byte, scopes *Scopes) (goshua.Bindings, error) {
+	eb := &Bindings{}
+	if err := json.Unmarshal(data, eb); err != nil {
+		return nil, err
+	}
+	return eb.Decode(scopes)
+}
+
+// EncodeGob writes the gob encoding of b to w.
+func EncodeGob(w io.Writer, b goshua.Bindings) error {
+	eb, err := EncodeBindings(b)
+	if err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(eb)
+}
+
+// DecodeGob reads the gob encoding of a Bindings from r.  Their Variables
+// are decoded in scopes.
+func DecodeGob(r io.Reader, scopes *Scopes) (goshua.Bindings, error) {
+	eb := &Bindings{}
+	if err := gob.NewDecoder(r).Decode(eb); err != nil {
+		return nil, err
+	}
+	return eb.Decode(scopes)
+}
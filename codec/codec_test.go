@@ -0,0 +1,244 @@
+package codec
+
+import "bytes"
+import "encoding/json"
+import "reflect"
+import "testing"
+import "goshua/goshua"
+import _ "goshua/bindings"
+import _ "goshua/equality"
+import _ "goshua/unification"
+import _ "goshua/variables"
+
+type point struct {
+	X, Y int
+}
+
+type Point struct {
+	X, Y  int
+	Label interface{}
+}
+
+func init() {
+	RegisterType("codec.Point", reflect.TypeOf(Point{}))
+}
+
+func mustScopes(t *testing.T, scopes ...goshua.Scope) *Scopes {
+	ss, err := NewScopes(scopes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+func TestVariableIdentity(t *testing.T) {
+	s := goshua.NewScope()
+	scopes := mustScopes(t, s)
+	v := s.Lookup("x")
+	ev, err := EncodeVariable(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev1 Variable
+	if err := json.Unmarshal(data, &ev1); err != nil {
+		t.Fatal(err)
+	}
+	if ev1.Decode(scopes) != v {
+		t.Errorf("%s decoded to a different variable", data)
+	}
+	if ea, _ := EncodeVariable(goshua.Any); !goshua.IsAny(ea.Decode(scopes)) {
+		t.Errorf("Any didn't decode to Any")
+	}
+	es, err := EncodeScope(s, "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es.Decode(scopes) != s {
+		t.Errorf("scope %s decoded to a different scope", es.ID)
+	}
+	// Another Scopes makes a Scope of its own for the ID, once.
+	other := mustScopes(t)
+	if s1 := es.Decode(other); s1 == s || ev1.Decode(other).Scope() != s1 {
+		t.Errorf("scope %s should decode to one new Scope", es.ID)
+	}
+	if err := other.Add(s); err == nil {
+		t.Errorf("adding a second Scope with ID %s should fail", es.ID)
+	}
+}
+
+func TestTerms(t *testing.T) {
+	s := goshua.NewScope()
+	scopes := mustScopes(t, s)
+	for _, term := range []interface{}{
+		nil, true, 3, int8(-4), uint16(5), 2.5, float32(0.1), complex(1, 2), "hi",
+		[]interface{}{1, "a", s.Lookup("x"), nil},
+		map[string]interface{}{"a": 1, "b": []interface{}{}, "c": []interface{}(nil)},
+		map[string]interface{}(nil), (*Point)(nil),
+		Point{X: 1, Y: 2, Label: "p"},
+		&Point{X: 3, Label: s.Lookup("l")},
+	} {
+		et, err := EncodeTerm(term)
+		if err != nil {
+			t.Errorf("EncodeTerm(%v): %s", term, err)
+			continue
+		}
+		data, err := json.Marshal(et)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var et1 Term
+		if err := json.Unmarshal(data, &et1); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := et1.Decode(scopes)
+		if err != nil {
+			t.Errorf("decoding %s: %s", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, term) {
+			t.Errorf("%#v decoded as %#v via %s", term, decoded, data)
+		}
+	}
+}
+
+func TestTermErrors(t *testing.T) {
+	for _, term := range []interface{}{
+		point{1, 2},
+		[]int{1},
+		map[int]interface{}{},
+	} {
+		if et, err := EncodeTerm(term); err == nil {
+			t.Errorf("EncodeTerm(%#v) should fail, not return %#v", term, et)
+		}
+	}
+	cyclic := &Point{}
+	cyclic.Label = cyclic
+	list := []interface{}{1, nil}
+	list[1] = list
+	m := map[string]interface{}{}
+	m["m"] = m
+	for _, term := range []interface{}{cyclic, list, m} {
+		if et, err := EncodeTerm(term); err == nil {
+			t.Errorf("EncodeTerm of a cyclic %T should fail, not return %#v", term, et)
+		}
+	}
+	// Shared structure that isn't cyclic can be encoded.
+	shared := &Point{X: 1}
+	if _, err := EncodeTerm([]interface{}{shared, shared}); err != nil {
+		t.Errorf("EncodeTerm of shared structure: %s", err)
+	}
+	if _, err := (&Term{Type: "nosuch.Type"}).Decode(mustScopes(t)); err == nil {
+		t.Errorf("decoding an unregistered type should fail")
+	}
+	// Null terms in JSON are errors.
+	for _, data := range []string{
+		`{"Classes":[{"Variables":[{"Scope":"s","Name":"x"}],"Value":{"Type":"[]interface {}","Elements":[null]}}]}`,
+		`{"Classes":[{"Variables":[{"Scope":"s","Name":"x"}],"Value":{"Type":"codec.Point","Fields":{"X":null}}}]}`,
+		`{"Classes":[{"Variables":[{"Scope":"s","Name":"x"}],"Value":{"Type":"map[string]interface {}","Fields":{"a":null}}}]}`,
+	} {
+		if b, err := UnmarshalJSON([]byte(data), mustScopes(t)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) should fail, not return %v", data, b)
+		}
+	}
+}
+
+func makeBindings(t *testing.T, s goshua.Scope) goshua.Bindings {
+	b := goshua.EmptyBindings()
+	var ok bool
+	for _, pair := range [][]interface{}{
+		{"a", s.Lookup("b")},
+		{"b", s.Lookup("c")},
+		{"c", Point{X: 1, Label: s.Lookup("d")}},
+		{"e", s.Lookup("f")},
+		{"g", []interface{}{1, 2}},
+	} {
+		if b, ok = b.Bind(s.Lookup(pair[0].(string)), pair[1]); !ok {
+			t.Fatalf("Bind %v failed", pair)
+		}
+	}
+	return b
+}
+
+func checkBindings(t *testing.T, s goshua.Scope, b goshua.Bindings) {
+	for _, name := range []string{"a", "b", "c"} {
+		if val, ok := b.Get(s.Lookup(name)); !ok || !reflect.DeepEqual(val, Point{X: 1, Label: s.Lookup("d")}) {
+			t.Errorf("%s should be the point, not %v %v", name, val, ok)
+		}
+	}
+	if val, ok := b.Get(s.Lookup("g")); !ok || !reflect.DeepEqual(val, []interface{}{1, 2}) {
+		t.Errorf("g should be [1 2], not %v %v", val, ok)
+	}
+	if _, ok := b.Get(s.Lookup("d")); ok {
+		t.Errorf("d should be unbound")
+	}
+	b1, ok := b.Bind(s.Lookup("e"), 7)
+	if !ok {
+		t.Fatalf("binding e failed")
+	}
+	if val, ok := b1.Get(s.Lookup("f")); !ok || val != 7 {
+		t.Errorf("e and f should be in one class, f is %v %v", val, ok)
+	}
+}
+
+func TestBindingsJSON(t *testing.T) {
+	s := goshua.NewScope()
+	data, err := MarshalJSON(makeBindings(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := UnmarshalJSON(data, mustScopes(t, s))
+	if err != nil {
+		t.Fatalf("UnmarshalJSON(%s): %s", data, err)
+	}
+	checkBindings(t, s, b)
+}
+
+func TestBindingsGob(t *testing.T) {
+	s := goshua.NewScope()
+	var buf bytes.Buffer
+	if err := EncodeGob(&buf, makeBindings(t, s)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodeGob(&buf, mustScopes(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBindings(t, s, b)
+}
+
+// TestNamedScope decodes Bindings against a scope known only by its ID, as
+// another process would.
+func TestNamedScope(t *testing.T) {
+	s := goshua.NewScope()
+	data, err := MarshalJSON(makeBindings(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eb Bindings
+	if err := json.Unmarshal(data, &eb); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := s.ID()
+	for _, c := range eb.Classes {
+		for _, v := range c.Variables {
+			if v.Scope != id {
+				t.Errorf("%s has scope %q, not %q", v.Name, v.Scope, id)
+			}
+		}
+	}
+	scopes := mustScopes(t)
+	b := mustDecode(t, &eb, scopes)
+	checkBindings(t, scopes.Scope(id), b)
+}
+
+func mustDecode(t *testing.T, eb *Bindings, scopes *Scopes) goshua.Bindings {
+	b, err := eb.Decode(scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
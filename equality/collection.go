@@ -0,0 +1,157 @@
+package equality
+
+import "reflect"
+
+// Two slices or arrays, in any combination, are equal if they have the
+// same length and their elements are equal.  A nil slice is equal to an
+// empty one.
+
+func equal_sequence_sequence(c *comparison, a, b interface{}) (bool, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Len() != vb.Len() {
+		c.differ(a, b, "different lengths")
+		return false, nil
+	}
+	if va.Kind() == reflect.Slice && vb.Kind() == reflect.Slice && c.seen(va, vb) {
+		return true, nil
+	}
+	for i := 0; i < va.Len(); i++ {
+		c.enterIndex(i)
+		eq, err := c.equal(va.Index(i).Interface(), vb.Index(i).Interface())
+		c.leave()
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Two maps are equal if they have equal keys with equal values.
+
+func equal_map_map(c *comparison, a, b interface{}) (bool, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Len() != vb.Len() {
+		c.differ(a, b, "different sizes")
+		return false, nil
+	}
+	if c.seen(va, vb) {
+		return true, nil
+	}
+	// Keys of the same exact type are equal only if they are ==, so
+	// they can be looked up directly.
+	exact := va.Type().Key() == vb.Type().Key() && exactKey(va.Type().Key())
+	iter := va.MapRange()
+	for iter.Next() {
+		var value reflect.Value
+		if exact {
+			value = vb.MapIndex(iter.Key())
+		} else {
+			value = c.findKey(vb, iter.Key().Interface())
+		}
+		c.enterKey(iter.Key().Interface())
+		if !value.IsValid() {
+			c.differ(iter.Value().Interface(), nil, "missing from the second map")
+			c.leave()
+			return false, nil
+		}
+		eq, err := c.equal(iter.Value().Interface(), value.Interface())
+		c.leave()
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// exactKey returns true if equal is == for values of type t: a bool,
+// integer or string of a predeclared type.
+func exactKey(t reflect.Type) bool {
+	if t.PkgPath() != "" {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// findKey returns the value in m of the key equal to key, or an invalid
+// reflect.Value if there isn't one.  Keys that can't be compared with
+// key aren't equal to it.
+func (c *comparison) findKey(m reflect.Value, key interface{}) reflect.Value {
+	iter := m.MapRange()
+	for iter.Next() {
+		if eq, err := c.trial().equal(key, iter.Key().Interface()); err == nil && eq {
+			return iter.Value()
+		}
+	}
+	return reflect.Value{}
+}
+
+// A pointer is equal to a value that isn't a pointer if it points to an
+// equal value.
+
+func equal_ptr_value(c *comparison, a, b interface{}) (bool, error) {
+	va := reflect.ValueOf(a)
+	if va.IsNil() {
+		return false, nil
+	}
+	return c.equal(va.Elem().Interface(), b)
+}
+
+func equal_value_ptr(c *comparison, a, b interface{}) (bool, error) {
+	return equal_ptr_value(c, b, a)
+}
+
+// A nil interface{} is equal to itself and to nil pointers, slices,
+// maps, channels and functions.
+
+func equal_nil_nil(a, b interface{}) (bool, error) {
+	return true, nil
+}
+
+func equal_nil_value(a, b interface{}) (bool, error) {
+	return reflect.ValueOf(b).IsNil(), nil
+}
+
+func equal_value_nil(a, b interface{}) (bool, error) {
+	return equal_nil_value(b, a)
+}
+
+// unrelatedKinds are the kinds of values, including nil, which are just
+// not equal when no function is registered for comparing them, as for a
+// string and a bool or a string and a number, rather than equal
+// returning an error.
+var unrelatedKinds = make(map[reflect.Kind]bool)
+
+func init() {
+	sequences := []reflect.Kind{reflect.Slice, reflect.Array}
+	for _, k1 := range sequences {
+		for _, k2 := range sequences {
+			deepDispatch[makeBiadicKey(k1, k2)] = equal_sequence_sequence
+		}
+	}
+	deepDispatch[makeBiadicKey(reflect.Map, reflect.Map)] = equal_map_map
+	for k := reflect.Bool; k <= reflect.Complex128; k++ {
+		unrelatedKinds[k] = true
+	}
+	unrelatedKinds[reflect.String] = true
+	unrelatedKinds[reflect.Invalid] = true
+	for k := reflect.Bool; k <= reflect.UnsafePointer; k++ {
+		if k == reflect.Ptr || k == reflect.Interface {
+			continue
+		}
+		deepDispatch[makeBiadicKey(reflect.Ptr, k)] = equal_ptr_value
+		deepDispatch[makeBiadicKey(k, reflect.Ptr)] = equal_value_ptr
+	}
+	biadicDispatch[makeBiadicKey(reflect.Invalid, reflect.Invalid)] = equal_nil_nil
+	for _, k := range []reflect.Kind{reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func} {
+		biadicDispatch[makeBiadicKey(reflect.Invalid, k)] = equal_nil_value
+		biadicDispatch[makeBiadicKey(k, reflect.Invalid)] = equal_value_nil
+	}
+}
@@ -1,8 +1,10 @@
-// Pachage equality implements a notion of equality for interface{} to
+// Package equality implements a notion of equality for interface{} to
 // be used by the unifier.
 package equality
 
+import "fmt"
 import "reflect"
+import "strconv"
 import "goshua/goshua"
 
 func makeBiadicKey(kind1, kind2 reflect.Kind) uint16 {
@@ -11,20 +13,191 @@ func makeBiadicKey(kind1, kind2 reflect.Kind) uint16 {
 
 var biadicDispatch = make(map[uint16]func(interface{}, interface{}) (bool, error))
 
+// deepDispatch holds the functions which need the state of the
+// comparison: those for kinds whose values contain other values, which
+// they compare as part of the same comparison, and those that compare
+// floating point numbers according to its FloatPolicy.
+var deepDispatch = make(map[uint16]func(*comparison, interface{}, interface{}) (bool, error))
+
+// comparison holds the state of a single call to equal.
+type comparison struct {
+	policy *goshua.FloatPolicy
+	// visited records the pairs of pointers, maps and slices being
+	// compared so that cyclic values can be compared.
+	visited map[visit]bool
+	// explain is set by equalDetail.  path is then the path to the
+	// values being compared and diff the first Difference found.
+	explain bool
+	path    []string
+	diff    *goshua.Difference
+}
+
+type visit struct {
+	p1, p2 uintptr
+	t1, t2 reflect.Type
+}
+
 func equal(a, b interface{}) (bool, error) {
+	return equalWithPolicy(installedPolicy(), a, b)
+}
+
+func equalWithPolicy(policy *goshua.FloatPolicy, a, b interface{}) (bool, error) {
+	c := comparison{policy: policy}
+	return c.equal(a, b)
+}
+
+func equalDetail(a, b interface{}) (bool, *goshua.Difference) {
+	return detail(installedPolicy(), a, b)
+}
+
+func detail(policy *goshua.FloatPolicy, a, b interface{}) (bool, *goshua.Difference) {
+	c := comparison{policy: policy, explain: true}
+	eq, err := c.equal(a, b)
+	if eq && err == nil {
+		return true, nil
+	}
+	return false, c.diff
+}
+
+func (c *comparison) equal(a, b interface{}) (bool, error) {
+	eq, err := c.dispatch(a, b)
+	if c.explain && c.diff == nil && (err != nil || !eq) {
+		reason := "not equal"
+		if err != nil {
+			reason = err.Error()
+		}
+		c.record(a, b, reason, err)
+	}
+	return eq, err
+}
+
+func (c *comparison) dispatch(a, b interface{}) (bool, error) {
+	if f, ok := ownEqual(a, b); ok {
+		return f(a, b)
+	}
 	va := reflect.ValueOf(a)
 	vb := reflect.ValueOf(b)
-	f, ok := biadicDispatch[makeBiadicKey(va.Kind(), vb.Kind())]
+	key := makeBiadicKey(va.Kind(), vb.Kind())
+	if f, ok := deepDispatch[key]; ok {
+		return f(c, a, b)
+	}
+	f, ok := biadicDispatch[key]
 	if !ok {
+		if unrelatedKinds[va.Kind()] && unrelatedKinds[vb.Kind()] {
+			c.differ(a, b, "different kinds")
+			return false, nil
+		}
 		return false, goshua.NewEqualError(a, b)
 	}
 	return f(a, b)
 }
 
+// ownEqual returns the function which says whether a and b are equal if
+// their types decide that themselves: the function passed to Register
+// for their types, or the GoshuaEqual method of either of them.
+func ownEqual(a, b interface{}) (func(a, b interface{}) (bool, error), bool) {
+	if f, ok := registeredEqual(reflect.ValueOf(a), reflect.ValueOf(b)); ok {
+		return f, true
+	}
+	if _, ok := a.(CanEqual); ok {
+		return func(a, b interface{}) (bool, error) {
+			return a.(CanEqual).GoshuaEqual(b)
+		}, true
+	}
+	if _, ok := b.(CanEqual); ok {
+		return func(a, b interface{}) (bool, error) {
+			return b.(CanEqual).GoshuaEqual(a)
+		}, true
+	}
+	return nil, false
+}
+
+// OwnEquality returns true if the types of a and b decide whether they
+// are equal themselves, with Register or CanEqual.  The unifier doesn't
+// take such values apart.
+func OwnEquality(a, b interface{}) bool {
+	_, ok := ownEqual(a, b)
+	return ok
+}
+
+// seen returns true if va and vb, which are pointers, maps or slices, are
+// already being compared.  Like reflect.DeepEqual, a comparison that
+// comes back to a pair it is already comparing treats them as equal;
+// if they aren't, that is found where they are first compared.
+func (c *comparison) seen(va, vb reflect.Value) bool {
+	v := visit{va.Pointer(), vb.Pointer(), va.Type(), vb.Type()}
+	if c.visited == nil {
+		c.visited = make(map[visit]bool)
+	}
+	if c.visited[v] {
+		return true
+	}
+	c.visited[v] = true
+	return false
+}
+
+// trial returns a comparison for trying two values which leaves c as it
+// is.  The pairs a trial that fails has visited aren't equal, and the
+// Differences it finds aren't what c is explaining.
+func (c *comparison) trial() *comparison {
+	return &comparison{policy: c.policy}
+}
+
+// differ records, if c is explaining, that a and b are the first values
+// found to differ, for reason.
+func (c *comparison) differ(a, b interface{}, reason string) {
+	if c.explain && c.diff == nil {
+		c.record(a, b, reason, nil)
+	}
+}
+
+func (c *comparison) record(a, b interface{}, reason string, err error) {
+	c.diff = &goshua.Difference{
+		Path:   append([]string(nil), c.path...),
+		A:      a,
+		B:      b,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// enterIndex, enterField and enterKey add a step to the path, if c is
+// explaining, and leave removes it.
+
+func (c *comparison) enterIndex(i int) {
+	if c.explain {
+		c.path = append(c.path, "["+strconv.Itoa(i)+"]")
+	}
+}
+
+func (c *comparison) enterField(name string) {
+	if c.explain {
+		c.path = append(c.path, "."+name)
+	}
+}
+
+func (c *comparison) enterKey(key interface{}) {
+	if c.explain {
+		c.path = append(c.path, fmt.Sprintf("[%#v]", key))
+	}
+}
+
+func (c *comparison) leave() {
+	if c.explain {
+		c.path = c.path[:len(c.path)-1]
+	}
+}
+
+// CanEqual is implemented by types which compare themselves.  equal
+// uses GoshuaEqual if either of the values it compares implements it.
 type CanEqual interface {
 	GoshuaEqual(interface{}) (bool, error)
 }
 
 func init() {
 	goshua.Equal = equal
+	goshua.EqualDetail = equalDetail
+	goshua.EqualWithPolicy = equalWithPolicy
+	goshua.SetFloatPolicy = setFloatPolicy
+	goshua.EqualIn = equalIn
 }
@@ -1,6 +1,9 @@
 package equality
 
+import "math"
+import "strings"
 import "testing"
+import "time"
 import "reflect"
 import "goshua/goshua"
 
@@ -56,13 +59,11 @@ func TestFloat(t *testing.T) {
 	test(t, false, float32(3.14), float32(1.414))
 }
 
-/*
 func TestComplex(t *testing.T) {
-  test(t, true, complex64(3+4i), complex64(3+4i))
-  test(t, true, complex64(3+4i), complex128(3+4i))
-  test(t, false, complex64(4+3i), complex128(3+4i))
+	test(t, true, complex64(3+4i), complex64(3+4i))
+	test(t, true, complex64(3+4i), complex128(3+4i))
+	test(t, false, complex64(4+3i), complex128(3+4i))
 }
-*/
 
 func TestString(t *testing.T) {
 	test(t, true, "abcdef", "abcdef")
@@ -99,3 +100,374 @@ func TestStruct(t *testing.T) {
 	test(t, false, &ts1, &ts3)
 
 }
+
+type equalityCase struct {
+	equal bool
+	a, b  interface{}
+}
+
+func testCases(t *testing.T, cases []equalityCase) {
+	for _, c := range cases {
+		test(t, c.equal, c.a, c.b)
+		test(t, c.equal, c.b, c.a)
+	}
+}
+
+func TestBool(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, true, true},
+		{true, false, false},
+		{false, true, false},
+	})
+}
+
+func TestSequence(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, []int{1, 2}, []int{1, 2}},
+		{true, []int{1, 2}, []int64{1, 2}},
+		{true, []int{1, 2}, []interface{}{1, uint8(2)}},
+		{true, []int{1, 2}, [2]int{1, 2}},
+		{true, [2]string{"a", "b"}, [2]string{"a", "b"}},
+		{true, []int(nil), []int{}},
+		{true, [][]int{{1}, {2, 3}}, [][]int{{1}, {2, 3}}},
+		{false, []int{1, 2}, []int{1, 3}},
+		{false, []int{1, 2}, []int{1, 2, 3}},
+		{false, []int{1, 2}, [3]int{1, 2, 0}},
+		{false, [][]int{{1}, {2, 3}}, [][]int{{1}, {2}}},
+	})
+}
+
+func TestMap(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, map[string]int{"a": 1, "b": 2}, map[string]int{"b": 2, "a": 1}},
+		{true, map[string]int{"a": 1}, map[string]interface{}{"a": int64(1)}},
+		{true, map[int]string{1: "a"}, map[uint8]string{1: "a"}},
+		{true, map[string]int(nil), map[string]int{}},
+		{true,
+			map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": true}}},
+			map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": true}}}},
+		{false, map[string]int{"a": 1}, map[string]int{"a": 2}},
+		{false, map[string]int{"a": 1}, map[string]int{"b": 1}},
+		{false, map[string]int{"a": 1}, map[string]int{"a": 1, "b": 2}},
+		{false, map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil}},
+	})
+}
+
+func TestInterface(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, nil, nil},
+		{true, []interface{}{nil, "a"}, []interface{}{nil, "a"}},
+		{true, nil, (*testStruct)(nil)},
+		{true, nil, []int(nil)},
+		{false, nil, 0},
+		{false, nil, ""},
+		{false, nil, &testStruct{}},
+		{false, []interface{}{nil}, []interface{}{0}},
+	})
+}
+
+// Values of different basic kinds are unequal rather than an error, as
+// they once were.
+func TestMixedKinds(t *testing.T) {
+	testCases(t, []equalityCase{
+		{false, "1", 1},
+		{false, "1", 1.0},
+		{false, nil, 1},
+		{false, true, 1},
+		{false, "true", true},
+		{false, []interface{}{1, "a"}, []interface{}{"a", 1}},
+	})
+}
+
+type anyKey interface{}
+
+// Keys that can't be compared with a key don't stop the search for an
+// equal one.
+func TestMapKeyErrors(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true,
+			map[anyKey]int{testStruct{A: 1}: 1, int64(2): 5},
+			map[interface{}]int{testStruct{A: 1}: 1, 2: 5}},
+		{false,
+			map[anyKey]int{testStruct{A: 1}: 1, int64(2): 5},
+			map[interface{}]int{testStruct{A: 2}: 1, 2: 5}},
+	})
+}
+
+// Keys of maps with the same key type are found with Equal, not ==,
+// unless == is Equal for that type.
+func TestMapKeySameType(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, map[interface{}]int{int8(5): 1}, map[interface{}]int{int64(5): 1}},
+		{true, map[float64]int{0: 1}, map[float64]int{math.Copysign(0, -1): 1}},
+		{false, map[interface{}]int{int8(5): 1}, map[interface{}]int{int64(6): 1}},
+		{true, map[string]int{"a": 1}, map[string]int{"a": 1}},
+	})
+	a := map[interface{}]int{int8(5): 1}
+	b := map[interface{}]int{int64(5): 1}
+	if order, err := goshua.Compare(a, b); err != nil || order != 0 {
+		t.Errorf("Compare(%v, %v) is %d, %v", a, b, order, err)
+	}
+}
+
+// uintptrs are unsigned integers.
+func TestUintptr(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, uintptr(1), uintptr(1)},
+		{true, uintptr(1), 1},
+		{true, uint8(1), uintptr(1)},
+		{false, uintptr(1), uintptr(2)},
+		{true, 1.0, uintptr(1)},
+	})
+	if order, err := goshua.Compare(uintptr(1), 2); err != nil || order >= 0 {
+		t.Errorf("Compare(uintptr(1), 2) is %d, %v", order, err)
+	}
+}
+
+// Trying the keys of one map against those of another doesn't leave the
+// pairs tried looking equal.
+func TestMapKeyTrials(t *testing.T) {
+	one, two := 1, 2
+	p1, p2 := &one, &two
+	q1, q2 := new(int), new(int)
+	*q1, *q2 = 1, 2
+	for i := 0; i < 100; i++ {
+		test(t, false,
+			[]interface{}{map[interface{}]int{p1: 0, p2: 0}, p1},
+			[]interface{}{map[*int]int{q1: 0, q2: 0}, q2})
+	}
+}
+
+// Structs with unexported fields can't be compared field by field unless
+// their type is registered.
+func TestUnexportedFields(t *testing.T) {
+	if eq, err := goshua.Equal(hidden{1}, hidden{1}); err == nil {
+		t.Errorf("Equal of structs with unexported fields is %v, not an error", eq)
+	}
+	now := time.Now()
+	if eq, err := goshua.Equal(now, now.UTC()); err != nil || !eq {
+		t.Errorf("Equal of registered times is %v, %v", eq, err)
+	}
+}
+
+func TestPointerValue(t *testing.T) {
+	i := 3
+	s := []int{1}
+	ts := testStruct{A: 1, B: "foo"}
+	testCases(t, []equalityCase{
+		{true, &i, 3},
+		{true, &i, uint8(3)},
+		{true, &s, []int{1}},
+		{true, &ts, testStruct{A: 1, B: "foo"}},
+		{false, &i, 4},
+		{false, (*int)(nil), 0},
+		{false, &ts, testStruct{A: 2, B: "foo"}},
+	})
+}
+
+func TestCrossFamily(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, int(2), float64(2.0)},
+		{true, int8(-2), float32(-2.0)},
+		{true, uint16(7), float64(7)},
+		{true, int64(1 << 62), float64(1 << 62)},
+		{true, uint64(1 << 63), float64(1 << 63)},
+		{false, int(2), float64(2.5)},
+		{false, int(-1), float64(-1.5)},
+		{false, uint(1), float64(-1)},
+		// float64(1<<53 + 1) rounds to 1<<53.
+		{false, int64(1<<53 + 1), float64(1 << 53)},
+		{false, uint64(1<<53 + 1), float64(1 << 53)},
+		{true, int64(-1 << 63), -float64(1 << 63)},
+		// 1<<63 doesn't fit in an int64.
+		{false, int64(1<<63 - 1), float64(1 << 63)},
+		{false, int(0), math.NaN()},
+		{false, int(0), math.Inf(1)},
+		{true, complex128(2 + 0i), int(2)},
+		{true, complex64(2 + 0i), uint8(2)},
+		{true, complex128(2.5 + 0i), float64(2.5)},
+		{false, complex128(2 + 1i), int(2)},
+		{false, complex128(2 + 0i), float64(2.5)},
+	})
+}
+
+type node struct {
+	Name     string
+	Parent   *node
+	Children []*node
+}
+
+// family makes a parent with two children which point back to it.
+func family(childName string) *node {
+	parent := &node{Name: "p"}
+	parent.Children = []*node{
+		{Name: "a", Parent: parent},
+		{Name: childName, Parent: parent},
+	}
+	return parent
+}
+
+func TestCycles(t *testing.T) {
+	ring := func(names ...string) *node {
+		first := &node{Name: names[0]}
+		n := first
+		for _, name := range names[1:] {
+			n.Parent = &node{Name: name}
+			n = n.Parent
+		}
+		n.Parent = first
+		return first
+	}
+	selfSlice := []interface{}{1, nil}
+	selfSlice[1] = selfSlice
+	otherSlice := []interface{}{1, nil}
+	otherSlice[1] = otherSlice
+	selfMap := map[string]interface{}{"a": 1}
+	selfMap["self"] = selfMap
+	otherMap := map[string]interface{}{"a": 1}
+	otherMap["self"] = otherMap
+	testCases(t, []equalityCase{
+		{true, family("b"), family("b")},
+		{false, family("b"), family("c")},
+		{true, family("b").Children[1], family("b").Children[1]},
+		{false, family("b").Children[0], family("c").Children[0]},
+		{true, ring("a", "b"), ring("a", "b")},
+		{false, ring("a", "b"), ring("a", "c")},
+		{true, selfSlice, otherSlice},
+		{true, selfMap, otherMap},
+	})
+}
+
+// sum is 0.1+0.2 computed at run time, which, unlike the constant, isn't
+// exactly 0.3.
+var tenth, fifth = 0.1, 0.2
+var sum = tenth + fifth
+
+func TestFloatPolicy(t *testing.T) {
+	testPolicy := func(policy goshua.FloatPolicy, cases []equalityCase) {
+		for _, c := range cases {
+			for _, pair := range [][2]interface{}{{c.a, c.b}, {c.b, c.a}} {
+				eq, err := goshua.EqualWithPolicy(&policy, pair[0], pair[1])
+				if err != nil {
+					t.Errorf("%s", err.Error())
+				} else if eq != c.equal {
+					t.Errorf("%+v: expected equality of %T(%v) and %T(%v) to be %v",
+						policy, pair[0], pair[0], pair[1], pair[1], c.equal)
+				}
+			}
+		}
+	}
+	testPolicy(goshua.FloatPolicy{}, []equalityCase{
+		{false, sum, 0.3},
+		{false, math.NaN(), math.NaN()},
+		{false, float32(0.1), float64(0.1)},
+	})
+	testPolicy(goshua.FloatPolicy{Absolute: 1e-9}, []equalityCase{
+		{true, sum, 0.3},
+		{false, 0.1, 0.2},
+		{true, int(3), 3.0000000001},
+		{true, uint(3), 2.9999999999},
+		{false, int(3), 3.1},
+		{true, complex(sum, 1), complex(0.3, 1)},
+		{true, complex(sum, 0), 0.3},
+		{false, math.Inf(1), math.Inf(-1)},
+		{true, math.Inf(1), math.Inf(1)},
+		{true, []float64{sum}, []float64{0.3}},
+	})
+	testPolicy(goshua.FloatPolicy{Relative: 1e-6}, []equalityCase{
+		{true, 1e20, 1e20 + 1e13},
+		{false, 1e20, 1.01e20},
+		{false, 1e-20, 2e-20},
+	})
+	testPolicy(goshua.FloatPolicy{NaNEqual: true}, []equalityCase{
+		{true, math.NaN(), math.NaN()},
+		{true, float32(math.NaN()), math.NaN()},
+		{false, math.NaN(), 0.0},
+	})
+	testPolicy(goshua.FloatPolicy{Float32Precision: true}, []equalityCase{
+		{true, float32(0.1), float64(0.1)},
+		{true, complex64(0.1 + 1i), complex128(0.1 + 1i)},
+		{true, float32(16777217), int(16777216)},
+		{false, 0.1, 0.10000001},
+	})
+}
+
+func TestSetFloatPolicy(t *testing.T) {
+	goshua.SetFloatPolicy(goshua.FloatPolicy{Absolute: 1e-9})
+	defer goshua.SetFloatPolicy(goshua.FloatPolicy{})
+	test(t, true, sum, 0.3)
+	test(t, false, 0.1, 0.2)
+}
+
+type item struct {
+	SKU string
+	Qty int
+}
+
+type order struct {
+	Items []item
+	Meta  map[string]interface{}
+}
+
+func TestEqualDetail(t *testing.T) {
+	base := func() order {
+		return order{
+			Items: []item{{"a", 1}, {"b", 2}},
+			Meta:  map[string]interface{}{"note": "x", "tags": []string{"p"}},
+		}
+	}
+	changed := func(change func(o *order)) order {
+		o := base()
+		change(&o)
+		return o
+	}
+	cases := []struct {
+		b      interface{}
+		path   string
+		reason string
+	}{
+		{changed(func(o *order) { o.Items[1].Qty = 3 }), ".Items[1].Qty", "not equal"},
+		{changed(func(o *order) { o.Items = o.Items[:1] }), ".Items", "different lengths"},
+		{changed(func(o *order) { o.Meta["tags"] = []string{"q"} }), `.Meta["tags"][0]`, "not equal"},
+		{changed(func(o *order) { o.Meta["note"] = true }), `.Meta["note"]`, "different kinds"},
+		{changed(func(o *order) { delete(o.Meta, "note"); o.Meta["other"] = "x" }),
+			`.Meta["note"]`, "missing from the second map"},
+		{item{"a", 1}, "", "different types"},
+	}
+	for _, c := range cases {
+		eq, diff := goshua.EqualDetail(base(), c.b)
+		if eq || diff == nil {
+			t.Errorf("%v: expected a Difference", c.b)
+			continue
+		}
+		if diff.PathString() != c.path || diff.Reason != c.reason {
+			t.Errorf("Difference is %q, %q, not %q, %q",
+				diff.PathString(), diff.Reason, c.path, c.reason)
+		}
+	}
+	if eq, diff := goshua.EqualDetail(base(), base()); !eq || diff != nil {
+		t.Errorf("equal values have a Difference: %v", diff)
+	}
+}
+
+func TestEqualErrorPath(t *testing.T) {
+	a := []interface{}{1, map[string]interface{}{"f": func() {}}}
+	b := []interface{}{1, map[string]interface{}{"f": func() {}}}
+	_, err := goshua.Equal(a, b)
+	if _, ok := err.(*goshua.Difference); ok || err == nil {
+		t.Fatalf("Equal should return the error itself, not %v", err)
+	}
+	_, diff := goshua.EqualDetail(a, b)
+	if diff == nil {
+		t.Fatalf("EqualDetail should explain the error")
+	}
+	if diff.PathString() != `[1]["f"]` || diff.Err == nil {
+		t.Errorf("Difference is %q, %v", diff.PathString(), diff.Err)
+	}
+	if diff.Err.Error() != err.Error() {
+		t.Errorf("Difference has error %v, not %v", diff.Err, err)
+	}
+	if !strings.Contains(diff.Error(), `[1]["f"]`) {
+		t.Errorf("Difference %q doesn't say where", diff.Error())
+	}
+}
@@ -1,5 +1,5 @@
 // expander is a program that generates a go source file of functions which
-// implement equality for different types.
+// implement equality and ordering for different types.
 //
 // To build and run:
 //
@@ -37,22 +37,38 @@ var signedIntegers = []interface{}{
 }
 
 var unsignedIntegers = []interface{}{
-	uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+	uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+}
+
+var floats = []interface{}{
+	float32(0), float64(0),
+}
+
+var complexes = []interface{}{
+	complex64(0), complex128(0),
 }
 
 type equalityGroup struct {
 	compareAs      string // Name of a method in reflect.Value to get the underlying type
 	parameterKinds []reflect.Kind
+	// policyMethod, if set, is the method of comparison which compares
+	// the values according to the FloatPolicy instead of ==.
+	policyMethod string
+	// orderFunction is the function which orders two values of the
+	// type that compareAs gets.
+	orderFunction string
 }
 
 var equalityGroups = []equalityGroup{
 	equalityGroup{
 		compareAs:      "String",
 		parameterKinds: []reflect.Kind{reflect.String},
+		orderFunction:  "compareStrings",
 	},
 	equalityGroup{
 		compareAs:      "Bool",
 		parameterKinds: []reflect.Kind{reflect.Bool},
+		orderFunction:  "compareBools",
 	},
 	equalityGroup{
 		compareAs: "Int",
@@ -63,6 +79,7 @@ var equalityGroups = []equalityGroup{
 			reflect.Int32,
 			reflect.Int64,
 		},
+		orderFunction: "compareInts",
 	},
 	equalityGroup{
 		compareAs: "Uint",
@@ -72,7 +89,9 @@ var equalityGroups = []equalityGroup{
 			reflect.Uint16,
 			reflect.Uint32,
 			reflect.Uint64,
+			reflect.Uintptr,
 		},
+		orderFunction: "compareUints",
 	},
 	equalityGroup{
 		compareAs: "Float",
@@ -80,6 +99,17 @@ var equalityGroups = []equalityGroup{
 			reflect.Float32,
 			reflect.Float64,
 		},
+		policyMethod:  "floatValuesEqual",
+		orderFunction: "compareFloats",
+	},
+	equalityGroup{
+		compareAs: "Complex",
+		parameterKinds: []reflect.Kind{
+			reflect.Complex64,
+			reflect.Complex128,
+		},
+		policyMethod:  "complexValuesEqual",
+		orderFunction: "compareComplexes",
 	},
 }
 
@@ -103,12 +133,28 @@ func init() {
 
 ` // equalPrototype
 
+const policyPrototype = `
+package equality
+func functionName(c *comparison, thing1, thing2 interface{}) (bool, error) {
+    return c.policyMethod(reflect.ValueOf(thing1), reflect.ValueOf(thing2)), nil
+}
+
+func init() {
+     deepDispatch[makeBiadicKey(kind1, kind2)] = functionName
+}
+
+` // policyPrototype
+
 // defineEqual builds an AST Node to define a function for testing if kind1
 // and kind2 are equal.  It does this by casting them both to targetKind
-// before using ==.
-func defineEqual(fset *token.FileSet, kind1, kind2 reflect.Kind, targetType string) []ast.Decl {
+// before using ==, or if policyMethod is set by calling that method.
+func defineEqual(fset *token.FileSet, kind1, kind2 reflect.Kind, targetType, policyMethod string) []ast.Decl {
 	function := go_tools.MustParse(fset, "equalPrototype", equalPrototype)
+	if policyMethod != "" {
+		function = go_tools.MustParse(fset, "policyPrototype", policyPrototype)
+	}
 	v := go_tools.NewSubstitutingVisitor()
+	v.Substitutions["policyMethod"] = policyMethod
 	v.Substitutions["kind1"] = fmt.Sprintf("reflect.%s", strings.Title(kind1.String()))
 	v.Substitutions["kind2"] = fmt.Sprintf("reflect.%s", strings.Title(kind2.String()))
 	v.Substitutions["functionName"] = equalName(kind1, kind2)
@@ -122,13 +168,47 @@ func doEqualityGroups(fset *token.FileSet, file *ast.File) {
 		for _, kind1 := range eg.parameterKinds {
 			for _, kind2 := range eg.parameterKinds {
 				targetKind := eg.compareAs
-				defs := defineEqual(fset, kind1, kind2, targetKind)
+				defs := defineEqual(fset, kind1, kind2, targetKind, eg.policyMethod)
 				addDefs(defs, file)
+				addDefs(defineOrder(fset, kind1, kind2, targetKind, eg.orderFunction), file)
 			}
 		}
 	}
 }
 
+// compareName generates a function name for the function to order
+// interfaces of kind1 and kind2.
+func compareName(kind1, kind2 reflect.Kind) string {
+	return fmt.Sprintf("compare_%s_%s", kind1.String(), kind2.String())
+}
+
+const orderPrototype = `
+package equality
+func functionName(c *comparison, thing1, thing2 interface{}) (int, error) {
+    return orderFunction(reflect.ValueOf(thing1).targetType(), reflect.ValueOf(thing2).targetType()), nil
+}
+
+func init() {
+     orderDispatch[makeBiadicKey(kind1, kind2)] = functionName
+}
+
+` // orderPrototype
+
+// defineOrder builds an AST Node to define a function for ordering kind1
+// and kind2.  It does this by casting them both to targetKind and
+// calling orderFunction.
+func defineOrder(fset *token.FileSet, kind1, kind2 reflect.Kind, targetType, orderFunction string) []ast.Decl {
+	function := go_tools.MustParse(fset, "orderPrototype", orderPrototype)
+	v := go_tools.NewSubstitutingVisitor()
+	v.Substitutions["orderFunction"] = orderFunction
+	v.Substitutions["kind1"] = fmt.Sprintf("reflect.%s", strings.Title(kind1.String()))
+	v.Substitutions["kind2"] = fmt.Sprintf("reflect.%s", strings.Title(kind2.String()))
+	v.Substitutions["functionName"] = compareName(kind1, kind2)
+	v.Substitutions["targetType"] = targetType
+	ast.Walk(v, function)
+	return function.Decls
+}
+
 const signedUnsignedPrototype = `
 package equality
 func integer_equal_signed_unsigned(signed, unsigned interface{}) (bool, error) {
@@ -178,6 +258,138 @@ func doSignedUnsigned(fset *token.FileSet, file *ast.File) {
 	}
 }
 
+const crossFamilyPrototype = `
+package equality
+func numeric_equal_signed_float(c *comparison, signed, float interface{}) (bool, error) {
+     f := reflect.ValueOf(float)
+     return c.floatEqualsSigned(f.Float(), reflect.ValueOf(signed).Int(), f.Kind() == reflect.Float32), nil
+}
+
+func numeric_equal_float_signed(c *comparison, float, signed interface{}) (bool, error) {
+     return numeric_equal_signed_float(c, signed, float)
+}
+
+func numeric_equal_unsigned_float(c *comparison, unsigned, float interface{}) (bool, error) {
+     f := reflect.ValueOf(float)
+     return c.floatEqualsUnsigned(f.Float(), reflect.ValueOf(unsigned).Uint(), f.Kind() == reflect.Float32), nil
+}
+
+func numeric_equal_float_unsigned(c *comparison, float, unsigned interface{}) (bool, error) {
+     return numeric_equal_unsigned_float(c, unsigned, float)
+}
+
+// A complex number is equal to a real one if its imaginary part is zero
+// and its real part is equal to the real number.
+func numeric_equal_complex_real(c *comparison, z, r interface{}) (bool, error) {
+     v := reflect.ValueOf(z)
+     is32 := v.Kind() == reflect.Complex64
+     if !c.floatsEqual(imag(v.Complex()), 0, is32) {
+     	return false, nil
+     }
+     if is32 {
+     	return c.equal(float32(real(v.Complex())), r)
+     }
+     return c.equal(real(v.Complex()), r)
+}
+
+func numeric_equal_real_complex(c *comparison, r, z interface{}) (bool, error) {
+     return numeric_equal_complex_real(c, z, r)
+}
+
+` // crossFamilyPrototype
+
+const crossFamilyOrderPrototype = `
+package equality
+func numeric_compare_signed_unsigned(c *comparison, signed, unsigned interface{}) (int, error) {
+     return compareSignedUnsigned(reflect.ValueOf(signed).Int(), reflect.ValueOf(unsigned).Uint()), nil
+}
+
+func numeric_compare_unsigned_signed(c *comparison, unsigned, signed interface{}) (int, error) {
+     order, err := numeric_compare_signed_unsigned(c, signed, unsigned)
+     return -order, err
+}
+
+func numeric_compare_float_signed(c *comparison, float, signed interface{}) (int, error) {
+     return compareFloatSigned(reflect.ValueOf(float).Float(), reflect.ValueOf(signed).Int()), nil
+}
+
+func numeric_compare_signed_float(c *comparison, signed, float interface{}) (int, error) {
+     order, err := numeric_compare_float_signed(c, float, signed)
+     return -order, err
+}
+
+func numeric_compare_float_unsigned(c *comparison, float, unsigned interface{}) (int, error) {
+     return compareFloatUnsigned(reflect.ValueOf(float).Float(), reflect.ValueOf(unsigned).Uint()), nil
+}
+
+func numeric_compare_unsigned_float(c *comparison, unsigned, float interface{}) (int, error) {
+     order, err := numeric_compare_float_unsigned(c, float, unsigned)
+     return -order, err
+}
+
+// A complex number is ordered against a real one by its real part, then
+// by its imaginary part against zero.
+func numeric_compare_complex_real(c *comparison, z, r interface{}) (int, error) {
+     v := reflect.ValueOf(z).Complex()
+     order, err := c.compare(real(v), r)
+     if err != nil || order != 0 {
+     	return order, err
+     }
+     return compareFloats(imag(v), 0), nil
+}
+
+func numeric_compare_real_complex(c *comparison, r, z interface{}) (int, error) {
+     order, err := numeric_compare_complex_real(c, z, r)
+     return -order, err
+}
+
+` // crossFamilyOrderPrototype
+
+const crossFamilyInit = `
+package equality
+func init() {
+     dispatch[makeBiadicKey(kind1, kind2)] = forward
+     dispatch[makeBiadicKey(kind2, kind1)] = backward
+}
+
+` // crossFamilyInit
+
+// doCrossFamily defines equality between integers and floats, and
+// between real and complex numbers, and ordering between all numbers of
+// different families.
+func doCrossFamily(fset *token.FileSet, file *ast.File) {
+	addDefs(go_tools.MustParse(fset, "crossFamilyPrototype", crossFamilyPrototype).Decls, file)
+	addDefs(go_tools.MustParse(fset, "crossFamilyOrderPrototype", crossFamilyOrderPrototype).Decls, file)
+	dispatch := "deepDispatch"
+	register := func(things1, things2 []interface{}, forward, backward string) {
+		for _, thing1 := range things1 {
+			for _, thing2 := range things2 {
+				function := go_tools.MustParse(fset, "crossFamilyInit", crossFamilyInit)
+				v := go_tools.NewSubstitutingVisitor()
+				v.Substitutions["kind1"] = fmt.Sprintf("reflect.%s", strings.Title(reflect.ValueOf(thing1).Kind().String()))
+				v.Substitutions["kind2"] = fmt.Sprintf("reflect.%s", strings.Title(reflect.ValueOf(thing2).Kind().String()))
+				v.Substitutions["forward"] = forward
+				v.Substitutions["backward"] = backward
+				v.Substitutions["dispatch"] = dispatch
+				ast.Walk(v, function)
+				addDefs(function.Decls, file)
+			}
+		}
+	}
+	register(signedIntegers, floats, "numeric_equal_signed_float", "numeric_equal_float_signed")
+	register(unsignedIntegers, floats, "numeric_equal_unsigned_float", "numeric_equal_float_unsigned")
+	for _, reals := range [][]interface{}{signedIntegers, unsignedIntegers, floats} {
+		register(complexes, reals, "numeric_equal_complex_real", "numeric_equal_real_complex")
+	}
+	dispatch = "orderDispatch"
+	register(signedIntegers, unsignedIntegers, "numeric_compare_signed_unsigned", "numeric_compare_unsigned_signed")
+	register(floats, signedIntegers, "numeric_compare_float_signed", "numeric_compare_signed_float")
+	register(floats, unsignedIntegers, "numeric_compare_float_unsigned", "numeric_compare_unsigned_float")
+	for _, reals := range [][]interface{}{signedIntegers, unsignedIntegers, floats} {
+		register(complexes, reals, "numeric_compare_complex_real", "numeric_compare_real_complex")
+	}
+}
+
 func main() {
 	fset := token.NewFileSet()
 	file, err := parser.ParseFile(fset, "", preamble, parser.Mode(0))
@@ -187,6 +399,7 @@ func main() {
 
 	doEqualityGroups(fset, file)
 	doSignedUnsigned(fset, file)
+	doCrossFamily(fset, file)
 
 	f, err := os.Create(os.ExpandEnv(outputFile))
 	if err != nil {
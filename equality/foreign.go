@@ -0,0 +1,219 @@
+package equality
+
+import "math"
+import "math/big"
+import "net"
+import "reflect"
+import "time"
+
+// Equality for types of the standard library which == and reflection
+// don't compare the way people expect.
+
+// Two times are equal if they are the same instant, whatever their
+// locations.
+
+func equalTimes(a, b interface{}) (bool, error) {
+	return a.(time.Time).Equal(b.(time.Time)), nil
+}
+
+// Times are ordered by instant.
+func compareTimes(a, b interface{}) (int, error) {
+	t1, t2 := a.(time.Time), b.(time.Time)
+	switch {
+	case t1.Before(t2):
+		return -1, nil
+	case t1.After(t2):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func hashTime(a interface{}) (uint64, error) {
+	t := a.(time.Time)
+	return mix(hashSigned(t.Unix()), uint64(t.Nanosecond())), nil
+}
+
+// Two IP addresses are equal if they are the same address, even if one is
+// a 4 byte IPv4 address and the other its 16 byte form.
+
+func equalIPs(a, b interface{}) (bool, error) {
+	return a.(net.IP).Equal(b.(net.IP)), nil
+}
+
+func hashIP(a interface{}) (uint64, error) {
+	ip := a.(net.IP)
+	if ip16 := ip.To16(); ip16 != nil {
+		ip = ip16
+	}
+	return hashString(string(ip)), nil
+}
+
+// big.Int, big.Rat and big.Float values, and pointers to them, are equal
+// to each other and to Go numbers that have the same value.
+
+// bigRat returns the exact value of the number n, or false if n is nil
+// or isn't finite.
+func bigRat(n interface{}) (*big.Rat, bool) {
+	switch x := n.(type) {
+	case *big.Int:
+		if x == nil {
+			return nil, false
+		}
+		return new(big.Rat).SetInt(x), true
+	case big.Int:
+		return new(big.Rat).SetInt(&x), true
+	case *big.Rat:
+		return x, x != nil
+	case big.Rat:
+		return &x, true
+	case *big.Float:
+		if x == nil || x.IsInf() {
+			return nil, false
+		}
+		r, _ := x.Rat(nil)
+		return r, true
+	case big.Float:
+		return bigRat(&x)
+	}
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(v.Float()) || math.IsInf(v.Float(), 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(v.Float()), true
+	}
+	return nil, false
+}
+
+// infinity returns the sign of n if it is an infinite float or
+// big.Float.
+func infinity(n interface{}) int {
+	switch x := n.(type) {
+	case *big.Float:
+		if x != nil && x.IsInf() {
+			return x.Sign()
+		}
+	case big.Float:
+		return infinity(&x)
+	case float32, float64:
+		f := reflect.ValueOf(n).Float()
+		if math.IsInf(f, 0) {
+			return int(math.Copysign(1, f))
+		}
+	}
+	return 0
+}
+
+func isNilPointer(n interface{}) bool {
+	v := reflect.ValueOf(n)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func equalNumbers(a, b interface{}) (bool, error) {
+	r1, ok1 := bigRat(a)
+	r2, ok2 := bigRat(b)
+	if ok1 && ok2 {
+		return r1.Cmp(r2) == 0, nil
+	}
+	if isNilPointer(a) || isNilPointer(b) {
+		return isNilPointer(a) && isNilPointer(b), nil
+	}
+	inf := infinity(a)
+	return inf != 0 && inf == infinity(b), nil
+}
+
+// compareNumbers orders a and b by value, as Compare orders Go numbers:
+// NaN comes first and infinities at the ends.  A nil pointer is nil.
+func compareNumbers(a, b interface{}) (int, error) {
+	if isNilPointer(a) || isNilPointer(b) {
+		return compareBools(!isNilPointer(a), !isNilPointer(b)), nil
+	}
+	r1, ok1 := bigRat(a)
+	r2, ok2 := bigRat(b)
+	if ok1 && ok2 {
+		return r1.Cmp(r2), nil
+	}
+	return compareInts(int64(extent(a, ok1)), int64(extent(b, ok2))), nil
+}
+
+// extent says where n, which isn't nil, comes among the numbers: -2 for
+// NaN, -1 for negative infinity, 0 for finite numbers and 1 for positive
+// infinity.
+func extent(n interface{}, finite bool) int {
+	if finite {
+		return 0
+	}
+	if inf := infinity(n); inf != 0 {
+		return inf
+	}
+	return -2
+}
+
+// hashNumber hashes n as hash does the Go number with the same value.
+func hashNumber(n interface{}) (uint64, error) {
+	if isNilPointer(n) {
+		return tagNil, nil
+	}
+	r, ok := bigRat(n)
+	if !ok {
+		if inf := infinity(n); inf != 0 {
+			return hashFloat(math.Inf(inf)), nil
+		}
+		return tagNaN, nil
+	}
+	if r.IsInt() {
+		i := r.Num()
+		switch {
+		case i.IsInt64():
+			return hashSigned(i.Int64()), nil
+		case i.IsUint64():
+			return mix(tagNonNegative, i.Uint64()), nil
+		}
+	}
+	if f, exact := r.Float64(); exact {
+		return hashFloat(f), nil
+	}
+	return hashString(r.String()), nil
+}
+
+// bigNumberTypes holds the types of math/big which are numbers.
+var bigNumberTypes = map[reflect.Type]bool{}
+
+func init() {
+	timeType := reflect.TypeOf(time.Time{})
+	Register(timeType, timeType, equalTimes)
+	RegisterCompare(timeType, timeType, compareTimes)
+	RegisterHash(timeType, hashTime)
+	ipType := reflect.TypeOf(net.IP{})
+	Register(ipType, ipType, equalIPs)
+	RegisterHash(ipType, hashIP)
+	bigTypes := []reflect.Type{
+		reflect.TypeOf(big.Int{}), reflect.TypeOf(&big.Int{}),
+		reflect.TypeOf(big.Rat{}), reflect.TypeOf(&big.Rat{}),
+		reflect.TypeOf(big.Float{}), reflect.TypeOf(&big.Float{}),
+	}
+	goTypes := []reflect.Type{
+		reflect.TypeOf(int(0)), reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)),
+		reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0)),
+		reflect.TypeOf(uint(0)), reflect.TypeOf(uint8(0)), reflect.TypeOf(uint16(0)),
+		reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0)),
+		reflect.TypeOf(float32(0)), reflect.TypeOf(float64(0)),
+	}
+	for i, t1 := range bigTypes {
+		bigNumberTypes[t1] = true
+		RegisterHash(t1, hashNumber)
+		for _, t2 := range bigTypes[i:] {
+			Register(t1, t2, equalNumbers)
+			RegisterCompare(t1, t2, compareNumbers)
+		}
+		for _, t2 := range goTypes {
+			Register(t1, t2, equalNumbers)
+			RegisterCompare(t1, t2, compareNumbers)
+		}
+	}
+}
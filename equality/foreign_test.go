@@ -0,0 +1,89 @@
+package equality
+
+import "math"
+import "math/big"
+import "net"
+import "reflect"
+import "testing"
+import "time"
+import "goshua/goshua"
+
+type celsius float64
+type fahrenheit float64
+
+func TestRegister(t *testing.T) {
+	Register(reflect.TypeOf(celsius(0)), reflect.TypeOf(fahrenheit(0)),
+		func(a, b interface{}) (bool, error) {
+			return float64(a.(celsius))*9/5+32 == float64(b.(fahrenheit)), nil
+		})
+	testCases(t, []equalityCase{
+		{true, celsius(100), fahrenheit(212)},
+		{false, celsius(100), fahrenheit(100)},
+		{true, []celsius{0}, []fahrenheit{32}},
+		// Other pairs of types are compared as before.
+		{true, celsius(100), float64(100)},
+	})
+}
+
+// ci is a string which is equal to any string with the same letters.
+type ci string
+
+func (c ci) GoshuaEqual(other interface{}) (bool, error) {
+	s, ok := other.(string)
+	if !ok {
+		s2, ok := other.(ci)
+		if !ok {
+			return false, nil
+		}
+		s = string(s2)
+	}
+	return lower(string(c)) == lower(s), nil
+}
+
+func TestCanEqualEitherSide(t *testing.T) {
+	testCases(t, []equalityCase{
+		{true, ci("Goshua"), "gOSHUA"},
+		{false, ci("Goshua"), "Goshu"},
+		{true, []interface{}{ci("A")}, []string{"a"}},
+	})
+}
+
+func TestForeignTypes(t *testing.T) {
+	utc := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	east := utc.In(time.FixedZone("east", 3600))
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	cases := []equalityCase{
+		{true, utc, east},
+		{true, &utc, east},
+		{false, utc, utc.Add(time.Nanosecond)},
+		{true, net.ParseIP("10.0.0.1"), net.IPv4(10, 0, 0, 1).To4()},
+		{false, net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+		{true, big.NewInt(5), big.NewInt(5)},
+		{true, big.NewInt(5), int8(5)},
+		{true, *big.NewInt(5), uint64(5)},
+		{true, big.NewInt(5), 5.0},
+		{false, big.NewInt(5), 5.5},
+		{true, big.NewRat(11, 2), 5.5},
+		{true, big.NewRat(10, 2), big.NewInt(5)},
+		{false, big.NewRat(1, 3), big.NewFloat(1.0 / 3)},
+		{true, big.NewFloat(0.5), big.NewRat(1, 2)},
+		{true, new(big.Float).SetInf(true), math.Inf(-1)},
+		{false, new(big.Float).SetInf(true), math.Inf(1)},
+		{false, huge, math.MaxInt64},
+		{true, new(big.Int).Lsh(big.NewInt(1), 70), math.Ldexp(1, 70)},
+		{true, (*big.Int)(nil), (*big.Rat)(nil)},
+		{false, (*big.Int)(nil), big.NewInt(0)},
+	}
+	testCases(t, cases)
+	for _, c := range cases {
+		if !c.equal {
+			continue
+		}
+		if mustHash(t, c.a) != mustHash(t, c.b) {
+			t.Errorf("%T(%v) and %T(%v) are equal but hash differently", c.a, c.a, c.b, c.b)
+		}
+	}
+	if goshua.Hash == nil {
+		t.Errorf("goshua.Hash isn't set")
+	}
+}
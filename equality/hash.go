@@ -0,0 +1,204 @@
+package equality
+
+import "fmt"
+import "math"
+import "reflect"
+import "goshua/goshua"
+
+// CanHash is implemented by types which hash themselves.  A type which
+// implements CanEqual should also implement CanHash, consistently.
+type CanHash interface {
+	GoshuaHash() (uint64, error)
+}
+
+// maxHashDepth is how many slices, arrays, maps and structs deep hash
+// looks.  Cyclic values which are equal, as equal sees them, have the
+// same unfolding to any depth, so stopping at a fixed depth keeps hash
+// consistent with equal for them.  Pointers don't count since a pointer
+// is equal to what it points to, but there can't be more than
+// maxPointerRun of them in a row.
+const maxHashDepth = 8
+const maxPointerRun = 64
+
+// Tags distinguish the hashes of values of different kinds.
+const (
+	tagNil uint64 = iota + 1
+	tagBool
+	tagNonNegative
+	tagNegative
+	tagFloat
+	tagNaN
+	tagImaginary
+	tagString
+	tagSequence
+	tagMap
+	tagStruct
+	tagDeep
+)
+
+func hash(term interface{}) (uint64, error) {
+	return hashValue(reflect.ValueOf(term), 0, 0)
+}
+
+// mix combines h with x.
+func mix(h, x uint64) uint64 {
+	return finalize(h*0x9e3779b97f4a7c15 ^ finalize(x))
+}
+
+// finalize is the splitmix64 finalizer.
+func finalize(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// hashValue hashes v, which is depth containers and, since the last of
+// them, run pointers deep.
+func hashValue(v reflect.Value, depth, run int) (uint64, error) {
+	if v.IsValid() && v.CanInterface() {
+		if fn, ok := registeredHash(v); ok {
+			return fn(v.Interface())
+		}
+		if o, ok := v.Interface().(CanHash); ok {
+			return o.GoshuaHash()
+		}
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return tagNil, nil
+	case reflect.Interface:
+		return hashValue(v.Elem(), depth, run)
+	case reflect.Bool:
+		if v.Bool() {
+			return mix(tagBool, 1), nil
+		}
+		return mix(tagBool, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return hashSigned(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mix(tagNonNegative, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return hashFloat(v.Float()), nil
+	case reflect.Complex64, reflect.Complex128:
+		z := v.Complex()
+		if imag(z) == 0 {
+			return hashFloat(real(z)), nil
+		}
+		return mix(mix(hashFloat(real(z)), tagImaginary), math.Float64bits(imag(z))), nil
+	case reflect.String:
+		return hashString(v.String()), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return tagNil, nil
+		}
+		if run >= maxPointerRun {
+			return tagDeep, nil
+		}
+		return hashValue(v.Elem(), depth, run+1)
+	case reflect.Slice, reflect.Array:
+		return hashSequence(v, depth)
+	case reflect.Map:
+		return hashMap(v, depth)
+	case reflect.Struct:
+		if depth >= maxHashDepth {
+			return tagDeep, nil
+		}
+		h := tagStruct
+		for i := 0; i < v.NumField(); i++ {
+			fh, err := hashValue(v.Field(i), depth+1, 0)
+			if err != nil {
+				return 0, err
+			}
+			h = mix(h, fh)
+		}
+		return h, nil
+	case reflect.Chan, reflect.Func:
+		if v.IsNil() {
+			return tagNil, nil
+		}
+	}
+	return 0, fmt.Errorf("goshua.Hash doesn't know how to hash %s", v.Type())
+}
+
+func hashSigned(i int64) uint64 {
+	if i < 0 {
+		return mix(tagNegative, uint64(i))
+	}
+	return mix(tagNonNegative, uint64(i))
+}
+
+// hashFloat hashes f as an integer if it is equal to one.
+func hashFloat(f float64) uint64 {
+	switch {
+	case math.IsNaN(f):
+		return tagNaN
+	case f != math.Trunc(f), f < -(1 << 63), f >= 1<<64:
+		return mix(tagFloat, math.Float64bits(f))
+	case f < 0:
+		return hashSigned(int64(f))
+	}
+	return mix(tagNonNegative, uint64(f))
+}
+
+// hashString is FNV-1a.
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return mix(tagString, h)
+}
+
+// An empty slice, array or map hashes as nil, since a nil slice or map,
+// which is equal to nil, is equal to an empty one.
+
+func hashSequence(v reflect.Value, depth int) (uint64, error) {
+	if v.Len() == 0 {
+		return tagNil, nil
+	}
+	if depth >= maxHashDepth {
+		return tagDeep, nil
+	}
+	h := tagSequence
+	for i := 0; i < v.Len(); i++ {
+		eh, err := hashValue(v.Index(i), depth+1, 0)
+		if err != nil {
+			return 0, err
+		}
+		h = mix(h, eh)
+	}
+	return h, nil
+}
+
+// hashMap adds up the hashes of the entries of the map v, so that their
+// order doesn't matter.
+func hashMap(v reflect.Value, depth int) (uint64, error) {
+	if v.Len() == 0 {
+		return tagNil, nil
+	}
+	if depth >= maxHashDepth {
+		return tagDeep, nil
+	}
+	var sum uint64
+	iter := v.MapRange()
+	for iter.Next() {
+		kh, err := hashValue(iter.Key(), depth+1, 0)
+		if err != nil {
+			return 0, err
+		}
+		vh, err := hashValue(iter.Value(), depth+1, 0)
+		if err != nil {
+			return 0, err
+		}
+		sum += mix(kh, vh)
+	}
+	return mix(tagMap, sum), nil
+}
+
+func init() {
+	goshua.Hash = hash
+}
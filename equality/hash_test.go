@@ -0,0 +1,125 @@
+package equality
+
+import "math"
+import "testing"
+import "goshua/goshua"
+
+func mustHash(t *testing.T, term interface{}) uint64 {
+	h, err := goshua.Hash(term)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	return h
+}
+
+func TestHashConsistentWithEqual(t *testing.T) {
+	for i, group := range ascending {
+		if i == 3 {
+			// NaN isn't equal to itself.
+			continue
+		}
+		h := mustHash(t, group[0])
+		for _, term := range group[1:] {
+			if mustHash(t, term) != h {
+				t.Errorf("%T(%v) and %T(%v) are equal but hash differently",
+					group[0], group[0], term, term)
+			}
+		}
+	}
+	empties := []interface{}{
+		nil, []int(nil), []int{}, [0]string{}, map[string]int(nil), map[int]int{},
+	}
+	for _, term := range empties {
+		if mustHash(t, term) != mustHash(t, nil) {
+			t.Errorf("%#v hashes differently from nil", term)
+		}
+	}
+	if mustHash(t, map[interface{}]int{int8(1): 1, "a": 2}) !=
+		mustHash(t, map[interface{}]float64{"a": 2, uint(1): 1}) {
+		t.Errorf("equal maps hash differently")
+	}
+}
+
+func TestHashSpread(t *testing.T) {
+	seen := make(map[uint64]interface{})
+	add := func(term interface{}) {
+		h := mustHash(t, term)
+		if other, ok := seen[h]; ok {
+			t.Errorf("%#v and %#v have the same hash", other, term)
+		}
+		seen[h] = term
+	}
+	for i := -500; i < 500; i++ {
+		add(i)
+		add(float64(i) + 0.5)
+		add(string(rune('a' + i + 500)))
+	}
+	add(true)
+	add(false)
+	add(math.Inf(1))
+	add([]int{1, 2})
+	add([]int{2, 1})
+	add(point{1, 2})
+	add(point{2, 1})
+}
+
+func TestHashCycles(t *testing.T) {
+	ring := func(names ...string) *node {
+		first := &node{Name: names[0]}
+		n := first
+		for _, name := range names[1:] {
+			n.Parent = &node{Name: name}
+			n = n.Parent
+		}
+		n.Parent = first
+		return first
+	}
+	pairs := [][2]interface{}{
+		{family("b"), family("b")},
+		{ring("a", "b"), ring("a", "b", "a", "b")},
+	}
+	for _, pair := range pairs {
+		if eq, _ := goshua.Equal(pair[0], pair[1]); !eq {
+			t.Errorf("cyclic values should be equal")
+		}
+		if mustHash(t, pair[0]) != mustHash(t, pair[1]) {
+			t.Errorf("equal cyclic values hash differently")
+		}
+	}
+	var p interface{}
+	p = &p
+	mustHash(t, p)
+}
+
+// caseless is a string which is equal to and hashes like any other
+// caseless string with the same letters.
+type caseless string
+
+func (c caseless) GoshuaHash() (uint64, error) {
+	return hashString(lower(string(c))), nil
+}
+
+func lower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func TestCanHash(t *testing.T) {
+	if mustHash(t, caseless("Goshua")) != mustHash(t, []caseless{"gOSHUA"}[0]) {
+		t.Errorf("CanHash wasn't used")
+	}
+	if mustHash(t, []interface{}{caseless("A")}) != mustHash(t, []caseless{"a"}) {
+		t.Errorf("CanHash wasn't used for elements")
+	}
+}
+
+func TestHashErrors(t *testing.T) {
+	if _, err := goshua.Hash(make(chan int)); err == nil {
+		t.Errorf("Hash of a channel should fail")
+	}
+}
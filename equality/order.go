@@ -0,0 +1,349 @@
+package equality
+
+import "math"
+import "reflect"
+import "sort"
+import "goshua/goshua"
+
+// orderDispatch holds the functions which order two values of the
+// specified kinds.  Those for numbers, strings and bools are generated.
+var orderDispatch = make(map[uint16]func(*comparison, interface{}, interface{}) (int, error))
+
+// kindRanks orders values of different kinds.  All numbers have the same
+// rank since they are ordered by value.
+var kindRanks = make(map[reflect.Kind]int)
+
+// CanCompare is implemented by types which order themselves.
+type CanCompare interface {
+	GoshuaCompare(interface{}) (int, error)
+}
+
+func compare(a, b interface{}) (int, error) {
+	c := comparison{policy: &goshua.FloatPolicy{}}
+	return c.compare(a, b)
+}
+
+func (c *comparison) compare(a, b interface{}) (int, error) {
+	if o, ok := a.(CanCompare); ok {
+		return o.GoshuaCompare(b)
+	}
+	if o, ok := b.(CanCompare); ok {
+		order, err := o.GoshuaCompare(a)
+		return -order, err
+	}
+	if f, ok := registeredCompare(reflect.ValueOf(a), reflect.ValueOf(b)); ok {
+		return f(a, b)
+	}
+	if f, ok := ownEqual(a, b); ok {
+		// Values whose types say when they are equal are otherwise
+		// ordered as usual, which had better tell them apart.
+		eq, err := f(a, b)
+		if err != nil || eq {
+			return 0, err
+		}
+		order, err := c.order(a, b)
+		if err == nil && order == 0 {
+			return 0, goshua.NewCompareError(a, b)
+		}
+		return order, err
+	}
+	return c.order(a, b)
+}
+
+// order orders a and b by their kinds and contents.
+func (c *comparison) order(a, b interface{}) (int, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Kind() == reflect.Ptr || vb.Kind() == reflect.Ptr {
+		if va.Kind() == reflect.Ptr && vb.Kind() == reflect.Ptr &&
+			!va.IsNil() && !vb.IsNil() && c.seen(va, vb) {
+			return 0, nil
+		}
+		return c.compare(pointee(va), pointee(vb))
+	}
+	if f, ok := orderDispatch[makeBiadicKey(va.Kind(), vb.Kind())]; ok {
+		return f(c, a, b)
+	}
+	r1, ok1 := c.rank(va)
+	r2, ok2 := c.rank(vb)
+	switch {
+	case !ok1 || !ok2:
+		return 0, goshua.NewCompareError(a, b)
+	case r1 != r2:
+		return compareInts(int64(r1), int64(r2)), nil
+	case r1 == kindRanks[reflect.Invalid]:
+		return 0, nil
+	}
+	return 0, goshua.NewCompareError(a, b)
+}
+
+// pointee returns what v points to if it is a pointer, nil if it is a
+// nil pointer, and otherwise v itself.
+func pointee(v reflect.Value) interface{} {
+	if v.Kind() != reflect.Ptr {
+		if !v.IsValid() {
+			return nil
+		}
+		return v.Interface()
+	}
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+// rank returns the rank of the kind of v.  Nil slices, maps, channels
+// and functions rank as nil.  Other channels and functions can't be
+// ordered.  The numbers of math/big rank as numbers.
+func (c *comparison) rank(v reflect.Value) (int, bool) {
+	if v.IsValid() && bigNumberTypes[v.Type()] {
+		return kindRanks[reflect.Int], true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Chan, reflect.Func:
+		if v.IsNil() {
+			return kindRanks[reflect.Invalid], true
+		}
+	}
+	r, ok := kindRanks[v.Kind()]
+	return r, ok
+}
+
+func compareInts(i1, i2 int64) int {
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(u1, u2 uint64) int {
+	switch {
+	case u1 < u2:
+		return -1
+	case u1 > u2:
+		return 1
+	}
+	return 0
+}
+
+func compareStrings(s1, s2 string) int {
+	switch {
+	case s1 < s2:
+		return -1
+	case s1 > s2:
+		return 1
+	}
+	return 0
+}
+
+func compareBools(b1, b2 bool) int {
+	switch {
+	case b1 == b2:
+		return 0
+	case b2:
+		return -1
+	}
+	return 1
+}
+
+// compareFloats orders f1 and f2.  NaN comes before every other number.
+func compareFloats(f1, f2 float64) int {
+	switch nan1, nan2 := math.IsNaN(f1), math.IsNaN(f2); {
+	case nan1 && nan2:
+		return 0
+	case nan1:
+		return -1
+	case nan2:
+		return 1
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	}
+	return 0
+}
+
+// compareComplexes orders z1 and z2 by their real parts, then by their
+// imaginary parts.
+func compareComplexes(z1, z2 complex128) int {
+	if order := compareFloats(real(z1), real(z2)); order != 0 {
+		return order
+	}
+	return compareFloats(imag(z1), imag(z2))
+}
+
+func compareSignedUnsigned(s int64, u uint64) int {
+	if s < 0 {
+		return -1
+	}
+	return compareUints(uint64(s), u)
+}
+
+// compareFloatSigned orders f and s exactly, without converting s to a
+// float, which can round.
+func compareFloatSigned(f float64, s int64) int {
+	switch {
+	case math.IsNaN(f), f < -(1 << 63):
+		return -1
+	case f >= 1<<63:
+		return 1
+	}
+	t := math.Trunc(f)
+	if order := compareInts(int64(t), s); order != 0 {
+		return order
+	}
+	return compareFloats(f, t)
+}
+
+// compareFloatUnsigned is compareFloatSigned for an unsigned integer.
+func compareFloatUnsigned(f float64, u uint64) int {
+	switch {
+	case math.IsNaN(f), f < 0:
+		return -1
+	case f >= 1<<64:
+		return 1
+	}
+	t := math.Trunc(f)
+	if order := compareUints(uint64(t), u); order != 0 {
+		return order
+	}
+	return compareFloats(f, t)
+}
+
+// Slices and arrays, in any combination, are ordered element by element.
+// If one is a prefix of the other the shorter one comes first.
+
+func compare_sequence_sequence(c *comparison, a, b interface{}) (int, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Kind() == reflect.Slice && vb.Kind() == reflect.Slice && c.seen(va, vb) {
+		return 0, nil
+	}
+	for i := 0; i < va.Len() && i < vb.Len(); i++ {
+		order, err := c.compare(va.Index(i).Interface(), vb.Index(i).Interface())
+		if err != nil || order != 0 {
+			return order, err
+		}
+	}
+	return compareInts(int64(va.Len()), int64(vb.Len())), nil
+}
+
+// Maps are ordered by size, then by their keys in order, then by the
+// values of those keys.
+
+func compare_map_map(c *comparison, a, b interface{}) (int, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if order := compareInts(int64(va.Len()), int64(vb.Len())); order != 0 {
+		return order, nil
+	}
+	if c.seen(va, vb) {
+		return 0, nil
+	}
+	keys1, err := c.sortedKeys(va)
+	if err != nil {
+		return 0, err
+	}
+	keys2, err := c.sortedKeys(vb)
+	if err != nil {
+		return 0, err
+	}
+	for i := range keys1 {
+		order, err := c.compare(keys1[i].Interface(), keys2[i].Interface())
+		if err != nil || order != 0 {
+			return order, err
+		}
+	}
+	for i := range keys1 {
+		order, err := c.compare(
+			va.MapIndex(keys1[i]).Interface(),
+			vb.MapIndex(keys2[i]).Interface())
+		if err != nil || order != 0 {
+			return order, err
+		}
+	}
+	return 0, nil
+}
+
+// sortedKeys returns the keys of the map m in order.
+func (c *comparison) sortedKeys(m reflect.Value) ([]reflect.Value, error) {
+	keys := m.MapKeys()
+	var err error
+	sort.Slice(keys, func(i, j int) bool {
+		order, e := c.trial().compare(keys[i].Interface(), keys[j].Interface())
+		if e != nil && err == nil {
+			err = e
+		}
+		return order < 0
+	})
+	return keys, err
+}
+
+// Structs of the same type are ordered field by field.  Structs of
+// different types are ordered by the names of their types.  Structs with
+// unexported fields can't be ordered.
+
+func compare_struct_struct(c *comparison, a, b interface{}) (int, error) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return compareTypes(va.Type(), vb.Type()), nil
+	}
+	for i := 0; i < va.NumField(); i++ {
+		if va.Type().Field(i).PkgPath != "" {
+			return 0, goshua.NewCompareError(a, b)
+		}
+	}
+	for i := 0; i < va.NumField(); i++ {
+		order, err := c.compare(
+			va.Field(i).Interface(),
+			vb.Field(i).Interface())
+		if err != nil || order != 0 {
+			return order, err
+		}
+	}
+	return 0, nil
+}
+
+func compareTypes(t1, t2 reflect.Type) int {
+	n1 := t1.PkgPath() + " " + t1.String()
+	n2 := t2.PkgPath() + " " + t2.String()
+	switch {
+	case n1 < n2:
+		return -1
+	case n1 > n2:
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	goshua.Compare = compare
+	ranks := [][]reflect.Kind{
+		{reflect.Invalid},
+		{reflect.Bool},
+		{reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128},
+		{reflect.String},
+		{reflect.Slice, reflect.Array},
+		{reflect.Map},
+		{reflect.Struct},
+	}
+	for rank, kinds := range ranks {
+		for _, k := range kinds {
+			kindRanks[k] = rank
+		}
+	}
+	sequences := []reflect.Kind{reflect.Slice, reflect.Array}
+	for _, k1 := range sequences {
+		for _, k2 := range sequences {
+			orderDispatch[makeBiadicKey(k1, k2)] = compare_sequence_sequence
+		}
+	}
+	orderDispatch[makeBiadicKey(reflect.Map, reflect.Map)] = compare_map_map
+	orderDispatch[makeBiadicKey(reflect.Struct, reflect.Struct)] = compare_struct_struct
+}
@@ -0,0 +1,242 @@
+package equality
+
+import "math"
+import "math/big"
+import "sort"
+import "testing"
+import "time"
+import "goshua/goshua"
+
+type point struct {
+	X, Y int
+}
+
+type label struct {
+	Name string
+}
+
+// ascending lists groups of values in order.  The values in each group
+// are equal to one another.
+var ascending = [][]interface{}{
+	{nil, (*int)(nil)},
+	{false},
+	{true},
+	{math.NaN(), float32(math.NaN())},
+	{math.Inf(-1)},
+	{int64(-1 << 63), -float64(1 << 63)},
+	{int8(-3), -3.0, complex(-3, 0)},
+	{-2.5},
+	{complex(-2.5, 1)},
+	{int(0), uint8(0), float32(0), complex64(0)},
+	{0.1},
+	{float32(0.1)},
+	{int16(2), uint64(2), 2.0, complex(2, 0), intPtr(2)},
+	{complex(2, 1)},
+	{int64(1<<53 + 1)},
+	{uint64(1<<63 - 1), int64(1<<63 - 1)},
+	{float64(1 << 63)},
+	{^uint64(0)},
+	{math.Inf(1)},
+	{""},
+	{"a"},
+	{"ab"},
+	{"b"},
+	{[]int{}, [0]string{}},
+	{[]int{1}, [1]float64{1}},
+	{[]int{1, 2}, []interface{}{1.0, uint(2)}},
+	{[]int{2}},
+	{map[string]int{}},
+	{map[string]int{"a": 2}},
+	{map[string]int{"b": 1}},
+	{map[string]int{"a": 1, "b": 1}, map[string]float64{"a": 1, "b": 1}},
+	{map[string]int{"a": 1, "b": 2}},
+	{label{"a"}},
+	{label{"b"}, &label{"b"}},
+	{point{1, 2}},
+	{point{2, 1}},
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestCompare(t *testing.T) {
+	checkOrder(t, ascending)
+}
+
+// checkOrder checks that Compare orders the values of groups, which are
+// in ascending order, consistently with one another and with Equal.
+func checkOrder(t *testing.T, groups [][]interface{}) {
+	sign := func(i int) int {
+		switch {
+		case i < 0:
+			return -1
+		case i > 0:
+			return 1
+		}
+		return 0
+	}
+	for i1, group1 := range groups {
+		for i2, group2 := range groups {
+			want := sign(i1 - i2)
+			for _, a := range group1 {
+				for _, b := range group2 {
+					order, err := goshua.Compare(a, b)
+					if err != nil {
+						t.Errorf("%s", err.Error())
+						continue
+					}
+					if sign(order) != want {
+						t.Errorf("Compare(%T(%v), %T(%v)) is %d, not %d",
+							a, a, b, b, order, want)
+					}
+					if eq, _ := goshua.Equal(a, a); !eq {
+						// NaN is equal to itself only when ordering.
+						continue
+					}
+					if eq, err := goshua.Equal(a, b); err == nil && eq != (want == 0) {
+						t.Errorf("Compare(%T(%v), %T(%v)) is %d but Equal is %v",
+							a, a, b, b, order, eq)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCompareNil(t *testing.T) {
+	// Nil slices and maps are ordered as empty ones.
+	for _, pair := range [][2]interface{}{
+		{[]int(nil), []string{}},
+		{map[int]int(nil), map[string]int{}},
+		{nil, (func())(nil)},
+		// As with Equal, they are also equal to nil.
+		{nil, []int(nil)},
+		{map[string]int(nil), nil},
+		{(*int)(nil), []int(nil)},
+	} {
+		if order, err := goshua.Compare(pair[0], pair[1]); err != nil || order != 0 {
+			t.Errorf("Compare(%#v, %#v) is %d, %v", pair[0], pair[1], order, err)
+		}
+	}
+}
+
+func TestCompareCycles(t *testing.T) {
+	if order, err := goshua.Compare(family("b"), family("b")); err != nil || order != 0 {
+		t.Errorf("Compare of equal cyclic values is %d, %v", order, err)
+	}
+	if order, err := goshua.Compare(family("b"), family("c")); err != nil || order >= 0 {
+		t.Errorf("Compare of cyclic values is %d, %v", order, err)
+	}
+}
+
+type hidden struct {
+	n int
+}
+
+func TestCompareErrors(t *testing.T) {
+	for _, pair := range [][2]interface{}{
+		{make(chan int), make(chan int)},
+		{func() {}, 1},
+		{hidden{1}, hidden{1}},
+		// Equal says they differ, but their fields are the same.
+		{never{1}, never{1}},
+	} {
+		if _, err := goshua.Compare(pair[0], pair[1]); err == nil {
+			t.Errorf("Compare(%T, %T) should fail", pair[0], pair[1])
+		}
+	}
+}
+
+// never is equal to nothing, not even itself.
+type never struct {
+	N int
+}
+
+func (n never) GoshuaEqual(other interface{}) (bool, error) {
+	return false, nil
+}
+
+// Values whose types decide their own equality are 0 when they are equal.
+func TestCompareOwnEquality(t *testing.T) {
+	utc := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	east := utc.In(time.FixedZone("east", 3600))
+	for _, pair := range [][2]interface{}{
+		{utc, east},
+		{ci("Goshua"), "gOSHUA"},
+		{"gOSHUA", ci("Goshua")},
+	} {
+		if order, err := goshua.Compare(pair[0], pair[1]); err != nil || order != 0 {
+			t.Errorf("Compare(%#v, %#v) is %d, %v", pair[0], pair[1], order, err)
+		}
+	}
+	if order, err := goshua.Compare(ci("B"), "c"); err != nil || order >= 0 {
+		t.Errorf("Compare of unequal ci is %d, %v", order, err)
+	}
+	if order, err := goshua.Compare(never{1}, never{2}); err != nil || order >= 0 {
+		t.Errorf("Compare of unequal nevers is %d, %v", order, err)
+	}
+}
+
+// The numbers of math/big are ordered by value among Go numbers.
+func TestCompareBigNumbers(t *testing.T) {
+	huge, _ := new(big.Int).SetString("1180591620717411303424", 10)
+	checkOrder(t, [][]interface{}{
+		{nil, (*big.Int)(nil)},
+		{math.NaN()},
+		{math.Inf(-1), new(big.Float).SetInf(true)},
+		{int8(-3), big.NewInt(-3), *big.NewInt(-3)},
+		{-2.5, big.NewRat(-5, 2), big.NewFloat(-2.5)},
+		{0, new(big.Int), *new(big.Rat)},
+		{big.NewRat(1, 3)},
+		{uint64(2), 2.0, big.NewInt(2), big.NewRat(4, 2)},
+		{1 << 62, new(big.Int).Lsh(big.NewInt(1), 62)},
+		{huge, new(big.Float).SetInt(huge)},
+		{math.Inf(1), new(big.Float).SetInf(false)},
+		{"a"},
+	})
+	terms := []interface{}{big.NewInt(6), 5, big.NewRat(11, 2), uint8(7), *big.NewInt(5)}
+	sort.Slice(terms, func(i, j int) bool {
+		order, err := goshua.Compare(terms[i], terms[j])
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+		return order < 0
+	})
+	want := []interface{}{5, 5, 5.5, 6, 7}
+	for i := range want {
+		if eq, _ := goshua.Equal(terms[i], want[i]); !eq {
+			t.Errorf("sorted terms are %v, not %v", terms, want)
+			break
+		}
+	}
+}
+
+// Times are ordered by instant, whatever their locations.
+func TestCompareTimes(t *testing.T) {
+	utc := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	east := time.FixedZone("east", 3600)
+	checkOrder(t, [][]interface{}{
+		{utc.Add(-time.Hour).In(east)},
+		{utc, utc.In(east)},
+		{utc.Add(1)},
+	})
+}
+
+func TestSortTerms(t *testing.T) {
+	terms := []interface{}{"b", 3, nil, 1.5, true, []int{1}, uint8(2)}
+	sort.Slice(terms, func(i, j int) bool {
+		order, err := goshua.Compare(terms[i], terms[j])
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+		return order < 0
+	})
+	want := []interface{}{nil, true, 1.5, uint8(2), 3, "b", []int{1}}
+	for i := range want {
+		if order, _ := goshua.Compare(terms[i], want[i]); order != 0 {
+			t.Errorf("sorted terms are %v, not %v", terms, want)
+			break
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package equality
 
 import "reflect"
-import "goshua/goshua"
 import "fmt"
 
 // Two pointers are equal if they are both nil, if they point to the
 // same object, or if the objects they point to are equal.
 
-func equal_ptr_ptr(a, b interface{}) (bool, error) {
+func equal_ptr_ptr(c *comparison, a, b interface{}) (bool, error) {
 	if reflect.ValueOf(a).IsNil() {
 		return reflect.ValueOf(b).IsNil(), nil
 	}
@@ -27,16 +26,12 @@ func equal_ptr_ptr(a, b interface{}) (bool, error) {
 		// Same object.
 		return true, nil
 	}
-	if o, ok := a.(CanEqual); ok {
-		return o.GoshuaEqual(b)
-	}
-	eq, err := goshua.Equal(va.Interface(), vb.Interface())
-	if err != nil {
-		return false, fmt.Errorf("%s", err)
+	if c.seen(reflect.ValueOf(a), reflect.ValueOf(b)) {
+		return true, nil
 	}
-	return eq, nil
+	return c.equal(va.Interface(), vb.Interface())
 }
 
 func init() {
-	biadicDispatch[makeBiadicKey(reflect.Ptr, reflect.Ptr)] = equal_ptr_ptr
+	deepDispatch[makeBiadicKey(reflect.Ptr, reflect.Ptr)] = equal_ptr_ptr
 }
@@ -0,0 +1,93 @@
+package equality
+
+import "math"
+import "reflect"
+import "sync/atomic"
+import "goshua/goshua"
+
+// policy holds the *goshua.FloatPolicy installed by setFloatPolicy.
+var policy atomic.Value
+
+func init() {
+	policy.Store(&goshua.FloatPolicy{})
+}
+
+func setFloatPolicy(p goshua.FloatPolicy) {
+	policy.Store(&p)
+}
+
+func installedPolicy() *goshua.FloatPolicy {
+	return policy.Load().(*goshua.FloatPolicy)
+}
+
+func equalIn(b goshua.Bindings, x, y interface{}) (bool, error) {
+	if fb, ok := b.(goshua.FloatPolicyBindings); ok {
+		if policy := fb.FloatPolicy(); policy != nil {
+			return equalWithPolicy(policy, x, y)
+		}
+	}
+	return equal(x, y)
+}
+
+// exact returns true if c compares numbers exactly.
+func (c *comparison) exact() bool {
+	return c.policy.Absolute == 0 && c.policy.Relative == 0
+}
+
+// floatsEqual compares f1 and f2 according to the FloatPolicy of c.  is32
+// is true if either of them was a float32.
+func (c *comparison) floatsEqual(f1, f2 float64, is32 bool) bool {
+	if math.IsNaN(f1) || math.IsNaN(f2) {
+		return c.policy.NaNEqual && math.IsNaN(f1) && math.IsNaN(f2)
+	}
+	if is32 && c.policy.Float32Precision {
+		f1, f2 = float64(float32(f1)), float64(float32(f2))
+	}
+	if f1 == f2 {
+		return true
+	}
+	if c.exact() || math.IsInf(f1, 0) || math.IsInf(f2, 0) {
+		return false
+	}
+	diff := math.Abs(f1 - f2)
+	return diff <= c.policy.Absolute ||
+		diff <= c.policy.Relative*math.Max(math.Abs(f1), math.Abs(f2))
+}
+
+// floatValuesEqual is floatsEqual for two reflect.Values of float kinds.
+func (c *comparison) floatValuesEqual(v1, v2 reflect.Value) bool {
+	is32 := v1.Kind() == reflect.Float32 || v2.Kind() == reflect.Float32
+	return c.floatsEqual(v1.Float(), v2.Float(), is32)
+}
+
+// complexValuesEqual compares the real and imaginary parts of two
+// reflect.Values of complex kinds with floatsEqual.
+func (c *comparison) complexValuesEqual(v1, v2 reflect.Value) bool {
+	is32 := v1.Kind() == reflect.Complex64 || v2.Kind() == reflect.Complex64
+	c1, c2 := v1.Complex(), v2.Complex()
+	return c.floatsEqual(real(c1), real(c2), is32) && c.floatsEqual(imag(c1), imag(c2), is32)
+}
+
+// floatEqualsSigned compares f, which is a float32 if is32 is true, with
+// the integer s.  When comparing exactly, f is converted to an integer
+// rather than s to a float since float64(s) can round.
+func (c *comparison) floatEqualsSigned(f float64, s int64, is32 bool) bool {
+	if !c.exact() || is32 && c.policy.Float32Precision {
+		return c.floatsEqual(f, float64(s), is32)
+	}
+	if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+		return false
+	}
+	return int64(f) == s
+}
+
+// floatEqualsUnsigned is floatEqualsSigned for an unsigned integer.
+func (c *comparison) floatEqualsUnsigned(f float64, u uint64, is32 bool) bool {
+	if !c.exact() || is32 && c.policy.Float32Precision {
+		return c.floatsEqual(f, float64(u), is32)
+	}
+	if f != math.Trunc(f) || f < 0 || f >= 1<<64 {
+		return false
+	}
+	return uint64(f) == u
+}
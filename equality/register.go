@@ -0,0 +1,124 @@
+package equality
+
+import "reflect"
+import "sync"
+import "sync/atomic"
+
+type typePair struct {
+	t1, t2 reflect.Type
+}
+
+// registered holds the map[typePair]func(interface{}, interface{}) (bool, error)
+// of functions passed to Register, and hashers the
+// map[reflect.Type]func(interface{}) (uint64, error) of functions passed
+// to RegisterHash.  Each is replaced rather than changed, so they can be
+// read without locking.  Before anything is registered they hold nil.
+var registered atomic.Value
+var hashers atomic.Value
+
+// comparers holds the map[typePair]func(interface{}, interface{}) (int, error)
+// of functions passed to RegisterCompare, in the same way.
+var comparers atomic.Value
+
+// registerLock serializes Register, RegisterHash and RegisterCompare.
+var registerLock sync.Mutex
+
+// Register makes equal use fn to compare values of the concrete types t1
+// and t2, in either order, in preference to how it would otherwise
+// compare them.  It is for types, such as those of other packages, that
+// can't implement CanEqual.  fn is always called with a value of type t1
+// as its first argument.  Values of the types should also be given a
+// consistent hash with RegisterHash.  goshua.Compare and goshua.Unify use
+// fn too: values it says are equal compare as 0 and unify.
+func Register(t1, t2 reflect.Type, fn func(a, b interface{}) (bool, error)) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	old, _ := registered.Load().(map[typePair]func(interface{}, interface{}) (bool, error))
+	m := make(map[typePair]func(interface{}, interface{}) (bool, error), len(old)+2)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[typePair{t1, t2}] = fn
+	if t1 != t2 {
+		m[typePair{t2, t1}] = func(a, b interface{}) (bool, error) {
+			return fn(b, a)
+		}
+	}
+	registered.Store(m)
+}
+
+// RegisterCompare makes goshua.Compare use fn to order values of the
+// concrete types t1 and t2, in either order.  fn is always called with a
+// value of type t1 as its first argument.  It should return 0 exactly
+// when the function passed to Register for the types says the values are
+// equal, and order them consistently with how Compare orders other
+// values.
+func RegisterCompare(t1, t2 reflect.Type, fn func(a, b interface{}) (int, error)) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	old, _ := comparers.Load().(map[typePair]func(interface{}, interface{}) (int, error))
+	m := make(map[typePair]func(interface{}, interface{}) (int, error), len(old)+2)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[typePair{t1, t2}] = fn
+	if t1 != t2 {
+		m[typePair{t2, t1}] = func(a, b interface{}) (int, error) {
+			order, err := fn(b, a)
+			return -order, err
+		}
+	}
+	comparers.Store(m)
+}
+
+// RegisterHash makes goshua.Hash use fn to hash values of the concrete
+// type t.
+func RegisterHash(t reflect.Type, fn func(interface{}) (uint64, error)) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	old, _ := hashers.Load().(map[reflect.Type]func(interface{}) (uint64, error))
+	m := make(map[reflect.Type]func(interface{}) (uint64, error), len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[t] = fn
+	hashers.Store(m)
+}
+
+// Registered returns true if a function passed to Register compares
+// values of type t with one another.
+func Registered(t reflect.Type) bool {
+	m, _ := registered.Load().(map[typePair]func(interface{}, interface{}) (bool, error))
+	_, ok := m[typePair{t, t}]
+	return ok
+}
+
+// registeredEqual returns the function passed to Register for the types
+// of va and vb, if there is one.
+func registeredEqual(va, vb reflect.Value) (func(interface{}, interface{}) (bool, error), bool) {
+	if !va.IsValid() || !vb.IsValid() {
+		return nil, false
+	}
+	m, _ := registered.Load().(map[typePair]func(interface{}, interface{}) (bool, error))
+	fn, ok := m[typePair{va.Type(), vb.Type()}]
+	return fn, ok
+}
+
+// registeredHash returns the function passed to RegisterHash for the
+// type of v, if there is one.
+func registeredHash(v reflect.Value) (func(interface{}) (uint64, error), bool) {
+	m, _ := hashers.Load().(map[reflect.Type]func(interface{}) (uint64, error))
+	fn, ok := m[v.Type()]
+	return fn, ok
+}
+
+// registeredCompare returns the function passed to RegisterCompare for
+// the types of va and vb, if there is one.
+func registeredCompare(va, vb reflect.Value) (func(interface{}, interface{}) (int, error), bool) {
+	if !va.IsValid() || !vb.IsValid() {
+		return nil, false
+	}
+	m, _ := comparers.Load().(map[typePair]func(interface{}, interface{}) (int, error))
+	fn, ok := m[typePair{va.Type(), vb.Type()}]
+	return fn, ok
+}
@@ -3,26 +3,30 @@ package equality
 import "reflect"
 import "goshua/goshua"
 
-import "log"
-
 // Two structs are equal if they are the same type and have the same content.
+// Structs with unexported fields can't be compared unless their type
+// decides its own equality.
 
-func equal_struct_struct(a, b interface{}) (bool, error) {
-	if o, ok := a.(CanEqual); ok {
-		return o.GoshuaEqual(b)
-	}
-	log.Printf("not CanEqual\n %T %v\n %T %v", a, a, b, b)
+func equal_struct_struct(c *comparison, a, b interface{}) (bool, error) {
 	va := reflect.ValueOf(a)
 	vb := reflect.ValueOf(b)
 	if va.Type() != vb.Type() {
+		c.differ(a, b, "different types")
 		return false, nil
 	}
 	for i := 0; i < va.NumField(); i++ {
-		eq, err := goshua.Equal(
+		if va.Type().Field(i).PkgPath != "" {
+			return false, goshua.NewEqualError(a, b)
+		}
+	}
+	for i := 0; i < va.NumField(); i++ {
+		c.enterField(va.Type().Field(i).Name)
+		eq, err := c.equal(
 			va.Field(i).Interface(),
 			vb.Field(i).Interface())
+		c.leave()
 		if err != nil {
-			return false, nil
+			return false, err
 		}
 		if !eq {
 			return false, nil
@@ -32,5 +36,5 @@ func equal_struct_struct(a, b interface{}) (bool, error) {
 }
 
 func init() {
-	biadicDispatch[makeBiadicKey(reflect.Struct, reflect.Struct)] = equal_struct_struct
+	deepDispatch[makeBiadicKey(reflect.Struct, reflect.Struct)] = equal_struct_struct
 }
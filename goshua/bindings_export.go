@@ -0,0 +1,32 @@
+package goshua
+
+// EquivalenceClasses returns the equivalence classes of the Variables of
+// b.  Each Variable of b appears in exactly one class.
+// EquivalenceClasses is set by whatever bindings implementation is linked
+// in.
+var EquivalenceClasses func(b Bindings) [][]Variable
+
+// Project returns a new Bindings of the same kind as b which only retains
+// what b says about variables: their values and which of them are bound
+// to one another.
+// Project is set by whatever bindings implementation is linked in.
+var Project func(b Bindings, variables ...Variable) Bindings
+
+// BindingsMap returns a map from the names of variables to their values
+// in b.  If no variables are specified then all of the Variables of b are
+// included.  Variables without a value are omitted.  Values are resolved
+// against b.  Variables from different Scopes that have the same name
+// will collide.
+// BindingsMap is set by whatever bindings implementation is linked in.
+var BindingsMap func(b Bindings, variables ...Variable) map[string]interface{}
+
+// BindingsJSON encodes BindingsMap(b, variables...) as JSON.
+// BindingsJSON is set by whatever bindings implementation is linked in.
+var BindingsJSON func(b Bindings, variables ...Variable) ([]byte, error)
+
+// Emptier is implemented by Bindings that can make an empty Bindings of
+// their own kind, with the same settings, such as their FloatPolicy.
+type Emptier interface {
+	Bindings
+	Empty() Bindings
+}
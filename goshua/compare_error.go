@@ -0,0 +1,32 @@
+package goshua
+
+import "fmt"
+
+// compareError is the type of error returned as the second value of
+// Compare.
+type compareError struct {
+	arg1 interface{}
+	arg2 interface{}
+}
+
+func (e *compareError) Error() string {
+	return fmt.Sprintf("goshua.Compare doesn't know how to order %T and %T",
+		e.arg1, e.arg2)
+}
+
+func (e *compareError) Arg1() interface{} {
+	return e.arg1
+}
+
+func (e *compareError) Arg2() interface{} {
+	return e.arg2
+}
+
+// NewCompareError creates an error to be returned as the second value of
+// goshua.Compare.
+func NewCompareError(arg1, arg2 interface{}) *compareError {
+	return &compareError{
+		arg1: arg1,
+		arg2: arg2,
+	}
+}
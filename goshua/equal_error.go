@@ -1,6 +1,7 @@
 package goshua
 
 import "fmt"
+import "strings"
 
 // equalError is the type of error returned as the second value of Equal.
 type equalError struct {
@@ -29,3 +30,49 @@ func NewEqualError(arg1, arg2 interface{}) *equalError {
 		arg2: arg2,
 	}
 }
+
+// Difference describes where two values that Equal says aren't equal, or
+// can't compare, first differ.
+type Difference struct {
+	// Path leads from the values that were compared to the elements
+	// that differ: field names such as ".Name", indexes such as "[2]"
+	// and map keys such as `["key"]`.  It is empty if the values differ
+	// at the top.
+	Path []string
+	// A and B are the elements that differ.
+	A, B interface{}
+	// Reason says how they differ.
+	Reason string
+	// Err is the error from comparing A and B if they couldn't be
+	// compared.
+	Err error
+}
+
+// PathString returns Path as a single string such as ".Items[2].Name".
+func (d *Difference) PathString() string {
+	return strings.Join(d.Path, "")
+}
+
+func (d *Difference) Error() string {
+	where := ""
+	if len(d.Path) > 0 {
+		where = " at " + d.PathString()
+	}
+	return fmt.Sprintf("%T %#v and %T %#v differ%s: %s", d.A, d.A, d.B, d.B, where, d.Reason)
+}
+
+// Unwrap returns the error from comparing A and B, if there was one.
+func (d *Difference) Unwrap() error {
+	return d.Err
+}
+
+// Explain returns err, which Equal returned when comparing a and b, as a
+// Difference saying where in them it happened.  It compares them again
+// with EqualDetail, so it is for reporting errors rather than for every
+// comparison.
+func Explain(a, b interface{}, err error) error {
+	if _, diff := EqualDetail(a, b); diff != nil && diff.Err != nil {
+		return diff
+	}
+	return err
+}
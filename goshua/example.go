@@ -0,0 +1,19 @@
+package goshua
+
+// QueryFromExample makes a Query from example, a struct or pointer to
+// one, for unifying against values of the same type.  Fields of example
+// that have their zero value don't matter.  Fields whose value is a
+// Variable are unified with that Variable.  Other fields must have the
+// value they have in example.  Nested structs and the structs that
+// pointer fields point to are treated in the same way, so only their
+// fields which are set matter.  Structs without exported fields, such as
+// time.Time, and values that define their own equality are compared as
+// a whole with Equal instead.
+//
+// A field can be marked to be bound whatever its value in example with
+// a goshua struct tag.  `goshua:"?name"` binds it to the Variable name
+// of scope and `goshua:"?"` to the Variable named for the field's path,
+// for example "Owner.Name".  `goshua:"-"` makes the field not matter.
+// Unexported fields are ignored.
+// It will get set by whatever implementation of Query is linked in.
+var QueryFromExample func(example interface{}, scope Scope) Query
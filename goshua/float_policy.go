@@ -0,0 +1,48 @@
+package goshua
+
+// FloatPolicy says how Equal compares floating point numbers, with each
+// other and with integers and complex numbers.  The zero FloatPolicy,
+// which is installed to begin with, compares them exactly.
+type FloatPolicy struct {
+	// Two numbers are equal if they differ by no more than Absolute, or
+	// by no more than Relative times the larger of their magnitudes.
+	Absolute float64
+	Relative float64
+	// NaNEqual makes NaN equal to NaN.
+	NaNEqual bool
+	// Float32Precision rounds both numbers to float32 precision before
+	// comparing them if either of them is a float32.
+	Float32Precision bool
+}
+
+// SetFloatPolicy installs the FloatPolicy that Equal uses unless the
+// Bindings of a unification say otherwise.
+var SetFloatPolicy func(policy FloatPolicy)
+
+// EqualWithPolicy is like Equal except that it uses policy rather than
+// the installed FloatPolicy.
+var EqualWithPolicy func(policy *FloatPolicy, a, b interface{}) (bool, error)
+
+// FloatPolicyBindings is implemented by Bindings which can carry a
+// FloatPolicy for the unifications that use them.
+type FloatPolicyBindings interface {
+	Bindings
+	// FloatPolicy returns the FloatPolicy of the receiver, or nil if
+	// it has none.
+	FloatPolicy() *FloatPolicy
+	// WithFloatPolicy returns Bindings with the same bindings as the
+	// receiver that carry policy.  Bindings made from them by Bind
+	// carry it too.
+	WithFloatPolicy(policy *FloatPolicy) Bindings
+}
+
+// WithFloatPolicy returns Bindings with the same bindings as b that make
+// the unifications they are used for compare floating point numbers
+// according to policy.  It panics if b is not a FloatPolicyBindings.
+// It will get set by whatever implementation of Bindings is linked in.
+var WithFloatPolicy func(b Bindings, policy FloatPolicy) Bindings
+
+// EqualIn is Equal for use while unifying with b.  It uses the
+// FloatPolicy of b if it has one.
+// It will get set by whatever implementation of equality is linked in.
+var EqualIn func(b Bindings, x, y interface{}) (bool, error)
@@ -34,23 +34,41 @@ type KnowledgeBase interface {
 var NewKb func() KnowledgeBase
 
 // Scope disambiguates logic variables with the same name from one another.
+// A Scope can be shared between goroutines.
 type Scope interface {
 	// Lookup returns the unique Variable with the specified name within Scope.
 	// A new Variable will be created if Scope does not already have a Variable
 	// with the specified name.
+	// Looking up the name "_" returns Any.
 	Lookup(name string) Variable
+
+	// ID returns an identifier for the Scope which is unique across
+	// processes.  A Scope with the same ID can be made with NamedScope.
+	// An error is returned if no ID can be made.
+	ID() (string, error)
 }
 
 // NewScope returns a new Scope.
 // It will get set by whatever implementation of Scope is linked in.
 var NewScope func() Scope
 
+// NamedScope returns a new Scope with the specified ID.  Variables can be
+// moved between processes by name and Scope ID.  Each call makes a
+// different Scope, so whatever decodes Variables keeps track of the Scopes
+// it has made, as codec.Scopes does.
+// It will get set by whatever implementation of Scope is linked in.
+var NamedScope func(id string) Scope
+
 // Variable represents a logic variable.
 type Variable interface {
 	Unifier
 	// Name returns the name of the logic variable.
 	Name() string
 
+	// Scope returns the Scope that the Variable belongs to.  Any belongs
+	// to no Scope.
+	Scope() Scope
+
 	// IsLogicVariable does nothing.
 	// Not everything with a name is a Variable.
 	IsLogicVariable()
@@ -60,6 +78,17 @@ type Variable interface {
 	SameAs(other Variable) bool
 }
 
+// Any is the anonymous wildcard Variable.  It unifies with anything and
+// never records a binding.  Each occurrence of Any is independent of every
+// other.
+// Any is set by whatever implementation of Variable is linked in.
+var Any Variable
+
+// IsAny returns true if thing is the anonymous wildcard Variable Any.
+func IsAny(thing interface{}) bool {
+	return Any != nil && thing == Any
+}
+
 // Bindings manages the binding of logic variables.
 type Bindings interface {
 	// We want to be able to unify one set of bindings to another.
@@ -71,33 +100,116 @@ type Bindings interface {
 	// and other have the same value, though that value might not yet be known.
 	// The second return value could be false if the new binding would cause an
 	// immediate contradiction.
+	// Binding Any, or binding a variable to Any, records nothing.
 	Bind(variable Variable, other interface{}) (Bindings, bool)
 
 	// Get returns the Variable's value, if it has one.
 	Get(variable Variable) (value interface{}, hasValue bool)
 
+	// Variables returns, in no particular order, the Variables that have a
+	// value or have been bound to another Variable.
+	Variables() []Variable
+
+	// EquivalenceClass returns the Variables that have been bound, directly
+	// or through other Variables, to variable.  The result includes variable.
+	EquivalenceClass(variable Variable) []Variable
+
 	// Dump the bindings, for debugging.
 	Dump()
 }
 
+// Trail is implemented by Bindings that record bindings in a mutable store
+// with an undo trail rather than in new immutable values.  A depth first
+// search marks a choice point before trying its alternatives and undoes
+// back to it once it is done with them.  Unify marks a choice point and
+// undoes back to it if the unification fails.
+type Trail interface {
+	Bindings
+
+	// Mark returns a choice point for the state that the receiver represents.
+	Mark() ChoicePoint
+
+	// Undo restores the store to the state it had at the choice point,
+	// undoing every binding made since.
+	Undo(ChoicePoint)
+}
+
+// ChoicePoint is an opaque value returned by Trail.Mark.
+type ChoicePoint interface{}
+
 // EmptyBindings returns a new, empty Bindings.
 // EmptyBindings is set by whatever bindings implementation is linked in.
 var EmptyBindings func() Bindings
 
 // Unify implements unification.  If the two things can be unified then
 // the continuation is called with the resulting Bindings as argument.
+// Values whose types decide their own equality, with equality.CanEqual or
+// equality.Register, unify if they are equal and aren't taken apart.
 // Unify is set by whatever implementation of unification is linked in.
 var Unify func(interface{}, interface{}, Bindings, func(Bindings))
 
+// Resolve returns a copy of term in which each Variable that has a value
+// in bindings has been replaced by that value.  Values are themselves
+// resolved, so links between variables are followed.  Only the parts of
+// term that contain such a Variable are copied; the rest, including
+// pointers, are returned as they are.  Variables without a
+// value are left in place and are also returned, in order of first
+// occurrence, as the second return value.  Any is left in place but not
+// reported.
+// Resolve is set by whatever implementation of unification is linked in.
+var Resolve func(term interface{}, bindings Bindings) (interface{}, []Variable)
+
+// Resolvable is implemented by types whose terms Resolve can't reach by
+// reflection, for example because they are in unexported fields.
+type Resolvable interface {
+	// Resolve returns a copy of the receiver in which resolve has been
+	// applied to each term that the receiver contains.
+	Resolve(resolve func(interface{}) interface{}) interface{}
+}
+
 // Equal implements the notion of equality used by Unify.
 // Go's == operator is very strict about what it thinks are equal, for
 // example int16(5) is not equal to int32(5).  We want something more
 // general.
+// Values of different basic kinds, such as the string "1" and the number
+// 1, or nil and 0, are not equal.
 // The first return value is whether the two arguments are equal.
 // The second return value is an error explaining why the values couldn't
 // be compared.
 var Equal func(interface{}, interface{}) (bool, error)
 
+// EqualDetail is like Equal but, if a and b aren't equal or can't be
+// compared, also returns a Difference saying where and why.  Equal
+// returns the error itself, which is the Err of the Difference.
+var EqualDetail func(a, b interface{}) (bool, *Difference)
+
+// Compare orders terms.  It returns a negative number if a comes before
+// b, a positive one if it comes after and 0 if they are equal.  Terms of
+// different kinds are ordered nil, bool, numbers, strings, slices and
+// arrays, maps, structs.  Numbers of every width are ordered by value, so
+// Compare is 0 exactly when Equal, with the exact FloatPolicy, is true.
+// The exceptions are that NaN comes before every other number and is
+// equal to NaN.  As with Equal, nil slices and maps are equal both to nil
+// and to empty ones.  Pointers are ordered by what they point to and a
+// nil pointer is nil.  Values whose types decide their own equality, with
+// equality.CanEqual or equality.Register, are 0 when that says they are
+// equal and are otherwise ordered as usual, unless equality.RegisterCompare
+// orders them.  The numbers of math/big are ordered among Go numbers by
+// value, and times by instant.
+// The second return value is an error explaining why the values couldn't
+// be ordered, for example because they are structs with unexported
+// fields.
+// Compare is set by whatever implementation of equality is linked in.
+var Compare func(a, b interface{}) (int, error)
+
+// Hash returns a hash of a term which is consistent with Equal, with the
+// exact FloatPolicy: if Equal(a, b) then Hash(a) == Hash(b), so int8(5)
+// and float64(5) have the same hash.  It can be used to make hash-based
+// sets and maps of terms.  The second return value is an error explaining
+// why the term couldn't be hashed.
+// Hash is set by whatever implementation of equality is linked in.
+var Hash func(term interface{}) (uint64, error)
+
 // Unifier is an interface for objects that can customize the behavior of Unify.
 type Unifier interface {
 	// Unify unifies the receiver against the provided interface{} and calls
@@ -116,10 +228,25 @@ type Query interface {
 
 // NewQuery makes a Query for unifying against a struct of type t with field.
 // values as described in fieldValues.  The values in fieldValues can be
-// Variables.  itself can be a Variable to bind the object being queried
-// against to if unification succeeds.
+// Variables.  The keys of fieldValues name reader methods or exported
+// fields, or are dotted paths of them such as "Owner.Address.City".
+// itself can be a Variable to bind the object being queried against to
+// if unification succeeds.  NewQuery panics if a key of fieldValues
+// doesn't name a method that takes no arguments and returns one value,
+// or an exported field.
 var NewQuery func(t reflect.Type, itself Variable, fieldValues map[string]interface{}) Query
 
+// MakeQuery is like NewQuery but returns an error describing every
+// problem with fieldValues rather than panicking.
+var MakeQuery func(t reflect.Type, itself Variable, fieldValues map[string]interface{}) (Query, error)
+
+// NewDocumentQuery makes a Query for unifying against documents: values
+// of type map[string]interface{}, such as encoding/json produces.  The
+// keys of keyValues are keys of the document or dotted paths of them.
+// itself can be a Variable to bind the document to if unification
+// succeeds.
+var NewDocumentQuery func(itself Variable, keyValues map[string]interface{}) Query
+
 // Predication types which implement their own storage (for example,
 // in an external database) implement the Tellable interface.
 type Tellable interface {
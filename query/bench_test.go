@@ -0,0 +1,129 @@
+package query
+
+import "fmt"
+import "reflect"
+import "testing"
+
+import "goshua/goshua"
+
+type order struct {
+	ID       int
+	Customer string
+	Total    float64
+	Shipping *address
+}
+
+func (o *order) GetID() interface{}       { return o.ID }
+func (o *order) GetCustomer() interface{} { return o.Customer }
+
+func makeOrders(n int) []interface{} {
+	cities := []string{"Oslo", "Bergen", "Tromsø"}
+	orders := make([]interface{}, n)
+	for i := range orders {
+		orders[i] = &order{
+			ID:       i,
+			Customer: fmt.Sprintf("c%d", i%100),
+			Total:    float64(i % 1000),
+			Shipping: &address{City: cities[i%len(cities)]},
+		}
+	}
+	return orders
+}
+
+// scanUncached matches each fact the way queries did before they were
+// compiled, looking each method up by name every time.
+func scanUncached(facts []interface{}, t reflect.Type, values map[string]interface{}) int {
+	count := 0
+	b := goshua.EmptyBindings()
+	for _, fact := range facts {
+		v := reflect.ValueOf(fact)
+		if v.Type() != t {
+			continue
+		}
+		matched := true
+		for name, val1 := range values {
+			method, _ := t.MethodByName(name)
+			val2 := method.Func.Call([]reflect.Value{v})[0].Interface()
+			cont := false
+			goshua.Unify(val1, val2, b, func(goshua.Bindings) {
+				cont = true
+			})
+			if !cont {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
+
+func scan(facts []interface{}, q goshua.Query) int {
+	count := 0
+	b := goshua.EmptyBindings()
+	matched := func(goshua.Bindings) {
+		count++
+	}
+	for _, fact := range facts {
+		goshua.Unify(q, fact, b, matched)
+	}
+	return count
+}
+
+const scanSize = 100000
+
+func BenchmarkScanMethodsUncached(b *testing.B) {
+	facts := makeOrders(scanSize)
+	values := map[string]interface{}{"GetCustomer": "c7", "GetID": 7}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n := scanUncached(facts, reflect.TypeOf(&order{}), values); n != 1 {
+			b.Fatalf("matched %d facts", n)
+		}
+	}
+}
+
+func benchmarkScan(b *testing.B, want int, values map[string]interface{}) {
+	facts := makeOrders(scanSize)
+	q := goshua.NewQuery(reflect.TypeOf(&order{}), nil, values)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n := scan(facts, q); n != want {
+			b.Fatalf("matched %d facts", n)
+		}
+	}
+}
+
+func BenchmarkScanMethods(b *testing.B) {
+	benchmarkScan(b, 1, map[string]interface{}{"GetCustomer": "c7", "GetID": 7})
+}
+
+func BenchmarkScanFields(b *testing.B) {
+	benchmarkScan(b, 1, map[string]interface{}{"Customer": "c7", "ID": 7})
+}
+
+func BenchmarkScanPath(b *testing.B) {
+	benchmarkScan(b, scanSize/3+1, map[string]interface{}{"Shipping.City": "Oslo"})
+}
+
+func BenchmarkScanVariable(b *testing.B) {
+	benchmarkScan(b, scanSize/100, map[string]interface{}{
+		"Customer": "c7",
+		"ID":       goshua.NewScope().Lookup("id"),
+	})
+}
+
+func BenchmarkScanPredicate(b *testing.B) {
+	benchmarkScan(b, scanSize/1000, map[string]interface{}{"Total": Between(10, 10.5)})
+}
+
+func BenchmarkNewQuery(b *testing.B) {
+	values := map[string]interface{}{"Customer": "c7", "Shipping.City": "Oslo", "GetID": 7}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		goshua.NewQuery(reflect.TypeOf(&order{}), nil, values)
+	}
+}
@@ -0,0 +1,128 @@
+package query
+
+import "reflect"
+import "sort"
+import "sync"
+import "goshua/goshua"
+
+// compiled is a matcher prepared for unifying against facts.
+type compiled struct {
+	name  string
+	path  path
+	value interface{}
+	// equal, if set, compares a value read by path with value directly
+	// rather than through goshua.Unify.  ok is false if it can't tell,
+	// for example because the value read is of a different type.
+	equal func(v reflect.Value) (eq bool, ok bool)
+}
+
+type pathKey struct {
+	t   reflect.Type
+	key string
+}
+
+// pathCache holds the path made for each type and matcher key so that
+// methods and fields are only looked up once, however many queries use
+// them.
+var pathCache = struct {
+	sync.RWMutex
+	paths map[pathKey]path
+}{paths: make(map[pathKey]path)}
+
+// cachedPath is makePath with caching.
+func cachedPath(t reflect.Type, key string) (path, error) {
+	k := pathKey{t, key}
+	pathCache.RLock()
+	p, ok := pathCache.paths[k]
+	pathCache.RUnlock()
+	if ok {
+		return p, nil
+	}
+	p, err := makePath(t, key)
+	if err != nil {
+		return nil, err
+	}
+	pathCache.Lock()
+	pathCache.paths[k] = p
+	pathCache.Unlock()
+	return p, nil
+}
+
+// compile prepares the matchers of q for unifying against facts.  The
+// paths for the matchers must already be in pathCache.
+func (q *query) compile() {
+	q.compiled = make([]compiled, 0, len(q.matchers))
+	for name, value := range q.matchers {
+		if goshua.IsAny(value) {
+			continue
+		}
+		p, _ := cachedPath(q.structType, name)
+		q.compiled = append(q.compiled, compiled{
+			name:  name,
+			path:  p,
+			value: value,
+			equal: fastEqual(value),
+		})
+	}
+	// Check in a fixed order.
+	sort.Slice(q.compiled, func(i, j int) bool {
+		return q.compiled[i].name < q.compiled[j].name
+	})
+}
+
+// fastEqual returns a function that compares values read from a fact
+// with value, if value is a bool, integer or string of a predeclared
+// type, for which goshua.Unify would just test equality.  Otherwise it
+// returns nil.
+func fastEqual(value interface{}) func(reflect.Value) (bool, bool) {
+	if value == nil {
+		return nil
+	}
+	if _, ok := value.(goshua.Unifier); ok {
+		return nil
+	}
+	vt := reflect.TypeOf(value)
+	if vt.PkgPath() != "" {
+		// Named types might have their own notion of equality.
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	// sameType returns the value read, unwrapped from any interface,
+	// if it has the type of value.
+	sameType := func(v reflect.Value) (reflect.Value, bool) {
+		if v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		return v, v.Type() == vt
+	}
+	switch vt.Kind() {
+	case reflect.Bool:
+		b := rv.Bool()
+		return func(v reflect.Value) (bool, bool) {
+			v, ok := sameType(v)
+			return ok && v.Bool() == b, ok
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		return func(v reflect.Value) (bool, bool) {
+			v, ok := sameType(v)
+			return ok && v.Int() == i, ok
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		return func(v reflect.Value) (bool, bool) {
+			v, ok := sameType(v)
+			return ok && v.Uint() == u, ok
+		}
+	case reflect.String:
+		s := rv.String()
+		return func(v reflect.Value) (bool, bool) {
+			v, ok := sameType(v)
+			return ok && v.String() == s, ok
+		}
+	}
+	return nil
+}
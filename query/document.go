@@ -0,0 +1,249 @@
+package query
+
+import "fmt"
+import "log"
+import "sort"
+import "strconv"
+import "strings"
+import "goshua/goshua"
+
+// document implements goshua.Query for facts that are documents: maps
+// from string to interface{}, as decoded by encoding/json, whose values
+// can be further documents or []interface{}.
+type document struct {
+	itself goshua.Variable
+	// Map from a key or dotted path of keys to an object to Unify the
+	// value found there against.
+	matchers map[string]interface{}
+	// paths holds the keys of each matcher's path.
+	paths map[string][]string
+}
+
+// newDocumentQuery is goshua.NewDocumentQuery.  It panics if
+// NewDocument returns an error.
+func newDocumentQuery(itself goshua.Variable, keyValues map[string]interface{}) goshua.Query {
+	q, err := NewDocument(itself, keyValues)
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
+// NewDocument makes a Query which unifies against documents.  The keys
+// of keyValues are keys of the document or dotted paths of them such as
+// "order.items.0.sku", in which a number indexes an array.  Their
+// values are unified with the values found in the document, except for
+// Present, Absent, Optional and AnyElement, which test them in other
+// ways.  A document which lacks a key doesn't match unless the matcher
+// is Absent or Optional.  If itself is provided it is bound to the
+// document that the Query matched.
+func NewDocument(itself goshua.Variable, keyValues map[string]interface{}) (goshua.Query, error) {
+	q := &document{
+		itself:   itself,
+		matchers: make(map[string]interface{}),
+		paths:    make(map[string][]string),
+	}
+	var problems []string
+	for key, val := range keyValues {
+		path := strings.Split(key, ".")
+		for _, k := range path {
+			if k == "" {
+				problems = append(problems, fmt.Sprintf("%q has an empty key", key))
+				break
+			}
+		}
+		q.matchers[key] = val
+		q.paths[key] = path
+	}
+	if problems != nil {
+		sort.Strings(problems)
+		return nil, &Error{Problems: problems}
+	}
+	return q, nil
+}
+
+func init() {
+	goshua.NewDocumentQuery = newDocumentQuery
+}
+
+func (q *document) IsQuery() bool { return true }
+
+// lookup returns the value at path in doc.
+func lookup(doc interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = d[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// Unify implements goshua.Unifier for document.  A document query can
+// unify against a document, or with another document query in the way
+// that queries on struct types do.
+func (q *document) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if thingQ, ok := thing.(*document); ok {
+		q.unifyDocument(thingQ, b, continuation)
+		return
+	}
+	doc, ok := thing.(map[string]interface{})
+	if !ok {
+		return
+	}
+	keys := make([]string, 0, len(q.matchers))
+	for key := range q.matchers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	q.match(doc, keys, b, continuation)
+}
+
+// match unifies the matchers for keys against doc in turn.  AnyElement
+// can match more than one way, so each way is tried.
+func (q *document) match(doc map[string]interface{}, keys []string, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if len(keys) == 0 {
+		if q.itself == nil {
+			continuation(b)
+		} else if b1, ok := b.Bind(q.itself, doc); ok {
+			continuation(b1)
+		}
+		return
+	}
+	matcher := q.matchers[keys[0]]
+	next := func(b1 goshua.Bindings) {
+		q.match(doc, keys[1:], b1, continuation)
+	}
+	if goshua.IsAny(matcher) {
+		next(b)
+		return
+	}
+	value, found := lookup(doc, q.paths[keys[0]])
+	switch m := matcher.(type) {
+	case presence:
+		if found == bool(m) {
+			next(b)
+		}
+		return
+	case *optional:
+		if !found {
+			next(b)
+			return
+		}
+		matcher = m.matcher
+	}
+	if found {
+		goshua.Unify(matcher, value, b, next)
+	}
+}
+
+func (q *document) unifyDocument(thingQ *document, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	for _, name := range matcherNames(q.matchers, thingQ.matchers) {
+		i1, ok1 := q.matchers[name]
+		i2, ok2 := thingQ.matchers[name]
+		cont := false
+		if goshua.IsAny(i1) || goshua.IsAny(i2) {
+			continue
+		} else if ok1 && ok2 {
+			b, cont = unifyOnce(i1, i2, b)
+		} else if ok1 || ok2 {
+			log.Printf("%s matcher missing", name)
+			return
+		}
+		if !cont {
+			return
+		}
+	}
+	continuation(b)
+}
+
+// Resolve implements goshua.Resolvable for document.  The matchers are
+// resolved.  itself is left as it is.
+func (q *document) Resolve(resolve func(interface{}) interface{}) interface{} {
+	q1 := &document{
+		itself:   q.itself,
+		matchers: make(map[string]interface{}),
+		paths:    q.paths,
+	}
+	for name, val := range q.matchers {
+		q1.matchers[name] = resolve(val)
+	}
+	return q1
+}
+
+// presence is the type of Present and Absent.
+type presence bool
+
+// Present matches any value for a key that a document has.
+const Present = presence(true)
+
+// Absent matches a key that a document lacks.
+const Absent = presence(false)
+
+// Unify implements goshua.Unifier for presence so that document queries
+// with the same presence test unify.
+func (p presence) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if p1, ok := thing.(presence); ok && p1 == p {
+		continuation(b)
+	}
+}
+
+type optional struct {
+	matcher interface{}
+}
+
+// Optional matches what matcher matches, or a key that a document lacks.
+func Optional(matcher interface{}) goshua.Unifier {
+	return &optional{matcher}
+}
+
+// Unify implements goshua.Unifier for optional.
+func (o *optional) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if o1, ok := thing.(*optional); ok {
+		thing = o1.matcher
+	}
+	goshua.Unify(o.matcher, thing, b, continuation)
+}
+
+// Resolve implements goshua.Resolvable for optional.
+func (o *optional) Resolve(resolve func(interface{}) interface{}) interface{} {
+	return &optional{resolve(o.matcher)}
+}
+
+type element struct {
+	matcher interface{}
+}
+
+// AnyElement matches an array which has an element that unifies with
+// matcher.  It unifies once for each such element.
+func AnyElement(matcher interface{}) goshua.Unifier {
+	return &element{matcher}
+}
+
+// Unify implements goshua.Unifier for element.
+func (e *element) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	switch t := thing.(type) {
+	case *element:
+		goshua.Unify(e.matcher, t.matcher, b, continuation)
+	case []interface{}:
+		for _, elt := range t {
+			goshua.Unify(e.matcher, elt, b, continuation)
+		}
+	}
+}
+
+// Resolve implements goshua.Resolvable for element.
+func (e *element) Resolve(resolve func(interface{}) interface{}) interface{} {
+	return &element{resolve(e.matcher)}
+}
@@ -0,0 +1,170 @@
+package query
+
+import "encoding/json"
+import "testing"
+
+import "goshua/goshua"
+import "goshua/unification"
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// solutions returns the Bindings of each way q unifies with thing.
+func solutions(q goshua.Query, thing interface{}, b goshua.Bindings) []goshua.Bindings {
+	var result []goshua.Bindings
+	goshua.Unify(q, thing, b, func(b1 goshua.Bindings) {
+		result = append(result, b1)
+	})
+	return result
+}
+
+const orderJSON = `{
+	"id": "o1",
+	"customer": {"name": "Ann", "address": {"city": "Oslo"}},
+	"items": [
+		{"sku": "a", "qty": 1},
+		{"sku": "b", "qty": 3},
+		{"sku": "c", "qty": 5}
+	]
+}`
+
+func TestDocumentQuery(t *testing.T) {
+	doc := decode(t, orderJSON)
+	scope := goshua.NewScope()
+	city := scope.Lookup("city")
+	sku := scope.Lookup("sku")
+	itself := scope.Lookup("itself")
+	q := goshua.NewDocumentQuery(itself, map[string]interface{}{
+		"id":                    "o1",
+		"customer.address.city": city,
+		"items.1.sku":           sku,
+		"items.0.qty":           Between(1, 2),
+		"note":                  Absent,
+		"customer":              Present,
+		"gift":                  Optional(true),
+		"customer.name":         Optional("Ann"),
+	})
+	s := solutions(q, doc, goshua.EmptyBindings())
+	if len(s) != 1 {
+		t.Fatalf("document query should have matched once, not %d times", len(s))
+	}
+	if val, ok := s[0].Get(city); !ok || val != "Oslo" {
+		t.Errorf("city should be Oslo, not %#v", val)
+	}
+	if val, ok := s[0].Get(sku); !ok || val != "b" {
+		t.Errorf("sku should be b, not %#v", val)
+	}
+	if _, ok := s[0].Get(itself); !ok {
+		t.Errorf("itself should be bound")
+	}
+	for key, matcher := range map[string]interface{}{
+		"note":              Present,
+		"customer":          Absent,
+		"customer.name":     Optional("Bo"),
+		"missing":           1,
+		"items.3.sku":       goshua.Any,
+		"items.x":           1,
+		"id.sub":            1,
+		"customer.address":  "Oslo",
+		"items.1.qty":       Gt(3),
+		"customer.nickname": "A",
+	} {
+		q := goshua.NewDocumentQuery(nil, map[string]interface{}{key: matcher})
+		if key == "items.3.sku" {
+			// goshua.Any doesn't care whether the key is there.
+			if len(solutions(q, doc, goshua.EmptyBindings())) != 1 {
+				t.Errorf("%s: goshua.Any should match", key)
+			}
+			continue
+		}
+		if len(solutions(q, doc, goshua.EmptyBindings())) != 0 {
+			t.Errorf("%s: %v should not match", key, matcher)
+		}
+	}
+	if len(solutions(q, "not a document", goshua.EmptyBindings())) != 0 {
+		t.Errorf("document query should not match a string")
+	}
+}
+
+func TestDocumentAnyElement(t *testing.T) {
+	doc := decode(t, orderJSON)
+	scope := goshua.NewScope()
+	sku := scope.Lookup("sku")
+	q := goshua.NewDocumentQuery(nil, map[string]interface{}{
+		"items": AnyElement(goshua.NewDocumentQuery(nil, map[string]interface{}{
+			"sku": sku,
+			"qty": Gt(2),
+		})),
+	})
+	var skus []interface{}
+	for _, b := range solutions(q, doc, goshua.EmptyBindings()) {
+		val, _ := b.Get(sku)
+		skus = append(skus, val)
+	}
+	if len(skus) != 2 || skus[0] != "b" || skus[1] != "c" {
+		t.Errorf("skus should be b and c, not %v", skus)
+	}
+}
+
+func TestDocumentQueries(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	q1 := goshua.NewDocumentQuery(nil, map[string]interface{}{
+		"a.b":  1,
+		"c":    Absent,
+		"d":    Optional(v),
+		"list": AnyElement(2),
+	})
+	for _, c := range []struct {
+		matchers map[string]interface{}
+		want     bool
+	}{
+		{map[string]interface{}{"a.b": 1, "c": Absent, "d": Optional(3), "list": AnyElement(2)}, true},
+		{map[string]interface{}{"a.b": v, "c": Absent, "d": 4, "list": AnyElement(goshua.Any)}, false},
+		{map[string]interface{}{"a.b": 1, "c": Absent, "d": 4, "list": AnyElement(goshua.Any)}, true},
+		{map[string]interface{}{"a.b": 1, "c": Present, "d": 4, "list": goshua.Any}, false},
+		{map[string]interface{}{"a.b": 1, "c": Absent, "list": goshua.Any}, false},
+	} {
+		q2 := goshua.NewDocumentQuery(nil, c.matchers)
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q1, q2, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() != c.want {
+			t.Errorf("document queries %v: got %v", c.matchers, !c.want)
+		}
+	}
+}
+
+func TestNewDocumentErrors(t *testing.T) {
+	if _, err := NewDocument(nil, map[string]interface{}{"a..b": 1, ".c": 2}); err == nil {
+		t.Errorf("NewDocument should reject empty keys")
+	} else if want := `query: ".c" has an empty key; "a..b" has an empty key`; err.Error() != want {
+		t.Errorf("wrong error: %s", err)
+	}
+}
+
+func TestResolveDocument(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	q := goshua.NewDocumentQuery(nil, map[string]interface{}{
+		"a": Optional(v),
+		"b": AnyElement(v),
+	})
+	b, _ := goshua.EmptyBindings().Bind(v, 2.0)
+	resolved, unbound := goshua.Resolve(q, b)
+	if len(unbound) != 0 {
+		t.Errorf("unbound variables %v", unbound)
+	}
+	doc := decode(t, `{"a": 2, "b": [1, 2]}`)
+	if len(solutions(resolved.(goshua.Query), doc, goshua.EmptyBindings())) != 1 {
+		t.Errorf("resolved document query should match")
+	}
+	doc = decode(t, `{"a": 3, "b": [1, 2]}`)
+	if len(solutions(resolved.(goshua.Query), doc, goshua.EmptyBindings())) != 0 {
+		t.Errorf("resolved document query should not match")
+	}
+}
@@ -0,0 +1,131 @@
+package query
+
+import "fmt"
+import "reflect"
+import "strings"
+import "goshua/equality"
+import "goshua/goshua"
+
+var canEqualType = reflect.TypeOf((*equality.CanEqual)(nil)).Elem()
+
+func init() {
+	goshua.QueryFromExample = queryFromExample
+}
+
+func queryFromExample(example interface{}, scope goshua.Scope) goshua.Query {
+	v := reflect.ValueOf(example)
+	s := v
+	if s.Kind() == reflect.Ptr {
+		s = s.Elem()
+	}
+	if s.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("QueryFromExample: %T is not a struct", example))
+	}
+	fieldValues := make(map[string]interface{})
+	exampleFields(s, "", scope, fieldValues, map[uintptr]bool{})
+	return newQuery(v.Type(), nil, fieldValues)
+}
+
+// exampleFields adds the matchers for the fields of the struct s to
+// fieldValues.  prefix is the path to s.  seen guards against cycles of
+// pointers.
+func exampleFields(s reflect.Value, prefix string, scope goshua.Scope, fieldValues map[string]interface{}, seen map[uintptr]bool) {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		path := prefix + f.Name
+		tag := f.Tag.Get("goshua")
+		switch {
+		case tag == "-":
+			continue
+		case strings.HasPrefix(tag, "?"):
+			if scope == nil {
+				panic(fmt.Sprintf("QueryFromExample: no Scope for field %s", path))
+			}
+			name := tag[1:]
+			if name == "" {
+				name = path
+			}
+			fieldValues[path] = scope.Lookup(name)
+			continue
+		case tag != "":
+			panic(fmt.Sprintf("QueryFromExample: bad goshua tag %q on field %s", tag, path))
+		}
+		fv := s.Field(i)
+		if fv.IsZero() {
+			continue
+		}
+		if v, ok := fv.Interface().(goshua.Variable); ok {
+			fieldValues[path] = v
+			continue
+		}
+		switch {
+		case leaf(fv.Type()) || fv.Kind() == reflect.Ptr && leaf(fv.Type().Elem()):
+			fieldValues[path] = &equalTo{fv.Interface()}
+		case fv.Kind() == reflect.Struct:
+			exampleFields(fv, path+".", scope, fieldValues, seen)
+		case fv.Kind() == reflect.Ptr && fv.Elem().Kind() == reflect.Struct:
+			if seen[fv.Pointer()] {
+				panic(fmt.Sprintf("QueryFromExample: cycle at field %s", path))
+			}
+			seen[fv.Pointer()] = true
+			exampleFields(fv.Elem(), path+".", scope, fieldValues, seen)
+			delete(seen, fv.Pointer())
+		case opaque(fv):
+			fieldValues[path] = &equalTo{fv.Interface()}
+		default:
+			fieldValues[path] = fv.Interface()
+		}
+	}
+}
+
+// leaf returns true if a set field of type t is matched as a whole with
+// goshua.Equal rather than field by field: if t defines its own equality
+// or is a struct without exported fields.
+func leaf(t reflect.Type) bool {
+	if t.Implements(canEqualType) || equality.Registered(t) {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// opaque returns true if v, or the value in it if it is an interface, is
+// a map or a pointer, which the unifier can't take apart.
+func opaque(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v.Kind() == reflect.Map || v.Kind() == reflect.Ptr
+}
+
+// *equalTo implements goshua.Unifier.  It unifies with things that are
+// equal to value.  The unifier would take apart a struct, and can't
+// unify pointers.
+type equalTo struct {
+	value interface{}
+}
+
+func (e *equalTo) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if v, ok := thing.(goshua.Variable); ok {
+		v.Unify(e.value, b, continuation)
+		return
+	}
+	if other, ok := thing.(*equalTo); ok {
+		thing = other.value
+	}
+	eq, err := goshua.EqualIn(b, e.value, thing)
+	if err == nil && eq {
+		continuation(b)
+	}
+}
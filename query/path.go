@@ -0,0 +1,161 @@
+package query
+
+import "fmt"
+import "go/token"
+import "reflect"
+import "strings"
+
+// A path reads a value from a fact.  It is made from a matcher key: the
+// name of a reader method or exported field, or a dotted sequence of
+// them such as "Owner.Address.City" which is followed through nested
+// structs and pointers.
+type path []step
+
+// step reads one name of a path.  Where the type of the value being read
+// is known when the query is made, the method or field is looked up
+// then.  Otherwise, for example after a method that returns
+// interface{}, it is looked up by name when the path is read.
+type step struct {
+	name string
+	// method is set for a reader method.
+	method *reflect.Method
+	// field is the index sequence of a field, as for
+	// reflect.Value.FieldByIndex.
+	field []int
+}
+
+// makePath makes the path for key starting from a value of type t.
+func makePath(t reflect.Type, key string) (path, error) {
+	var p path
+	for _, name := range strings.Split(key, ".") {
+		s := step{name: name}
+		if name == "" {
+			return nil, fmt.Errorf("%q has an empty name", key)
+		}
+		if !token.IsExported(name) {
+			return nil, fmt.Errorf("%s: %s is unexported", key, name)
+		}
+		if t == nil {
+			// The type was not known for the previous step.
+		} else if t.Kind() == reflect.Interface {
+			// The method is looked up in the dynamic type of the
+			// value when the path is read.
+			if m, ok := t.MethodByName(name); ok {
+				if err := checkReader(key, t, m, 0); err != nil {
+					return nil, err
+				}
+				t = m.Type.Out(0)
+			} else if t.NumMethod() != 0 {
+				return nil, fmt.Errorf("%s: no method %s for interface %v", key, name, t)
+			} else {
+				t = nil
+			}
+		} else if m, ok := t.MethodByName(name); ok {
+			if err := checkReader(key, t, m, 1); err != nil {
+				return nil, err
+			}
+			s.method = &m
+			t = m.Type.Out(0)
+		} else if f, ok := structField(t, name); ok {
+			s.field = f.Index
+			t = f.Type
+		} else {
+			return nil, fmt.Errorf("%s: no method or field %s for type %v", key, name, t)
+		}
+		p = append(p, s)
+	}
+	return p, nil
+}
+
+// checkReader returns an error if m, a method of t, takes arguments or
+// doesn't return a single value.  in is the number of parameters that
+// m.Type has for the receiver.
+func checkReader(key string, t reflect.Type, m reflect.Method, in int) error {
+	if m.Type.NumIn() != in {
+		return fmt.Errorf("%s: method %s of %v takes arguments", key, m.Name, t)
+	}
+	if m.Type.NumOut() != 1 {
+		return fmt.Errorf("%s: method %s of %v returns %d values", key, m.Name, t, m.Type.NumOut())
+	}
+	return nil
+}
+
+// structField looks up the exported field name of t, or of the struct t
+// points to.
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	f, ok := t.FieldByName(name)
+	if !ok || f.PkgPath != "" {
+		return reflect.StructField{}, false
+	}
+	return f, true
+}
+
+// read returns the value p reads from v.  It returns false if a nil
+// pointer or interface is met along the way or, for steps looked up
+// when read, if a name can't be found.
+func (p path) read(v reflect.Value) (reflect.Value, bool) {
+	for i := range p {
+		var ok bool
+		if v, ok = p[i].read(v); !ok {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// valueInterface returns the value v holds.
+func valueInterface(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Interface && v.IsNil() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func (s *step) read(v reflect.Value) (reflect.Value, bool) {
+	switch {
+	case s.method != nil:
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return v, false
+		}
+		return s.method.Func.Call([]reflect.Value{v})[0], true
+	case s.field != nil:
+		return fieldByIndex(v, s.field)
+	}
+	// Look the name up in the dynamic type of v.
+	for v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return v, false
+	}
+	p, err := cachedPath(v.Type(), s.name)
+	if err != nil || p[0].method == nil && p[0].field == nil {
+		return v, false
+	}
+	return p[0].read(v)
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex except that it follows
+// pointers, including those of embedded structs, and returns false rather
+// than panicking when one is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
@@ -0,0 +1,268 @@
+package query
+
+import "fmt"
+import "math"
+import "reflect"
+import "regexp"
+import "goshua/goshua"
+
+// Predicate is a matcher which, rather than being unified with the value
+// a query reads, tests it.  Arguments of predicates can be Variables, in
+// which case their values in the Bindings are used.  A Predicate doesn't
+// unify with an unbound Variable.
+type Predicate struct {
+	description string
+	test        func(value interface{}, b goshua.Bindings) bool
+	// bind, if set, is bound to the value if the test succeeds.
+	bind goshua.Variable
+}
+
+func (p *Predicate) String() string {
+	return p.description
+}
+
+// Unify implements goshua.Unifier for Predicate.
+func (p *Predicate) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
+	if thing == p {
+		continuation(b)
+		return
+	}
+	value, ok := valueOf(thing, b)
+	if !ok || !p.test(value, b) {
+		return
+	}
+	if p.bind == nil {
+		continuation(b)
+		return
+	}
+	if b1, ok := b.Bind(p.bind, value); ok {
+		continuation(b1)
+	}
+}
+
+// valueOf returns the value of thing, which is looked up in b if thing
+// is a Variable.
+func valueOf(thing interface{}, b goshua.Bindings) (interface{}, bool) {
+	v, ok := thing.(goshua.Variable)
+	if !ok {
+		return thing, true
+	}
+	if goshua.IsAny(v) {
+		return nil, false
+	}
+	return b.Get(v)
+}
+
+// comparison makes a Predicate that compares the value with arg and
+// accepts the result of compare if ok returns true for it.
+func comparison(name string, arg interface{}, ok func(int) bool) *Predicate {
+	return &Predicate{
+		description: fmt.Sprintf("%s(%v)", name, arg),
+		test: func(value interface{}, b goshua.Bindings) bool {
+			a, found := valueOf(arg, b)
+			if !found {
+				return false
+			}
+			c, comparable := compare(value, a)
+			return comparable && ok(c)
+		},
+	}
+}
+
+// Gt matches values greater than arg.
+func Gt(arg interface{}) *Predicate {
+	return comparison("Gt", arg, func(c int) bool { return c > 0 })
+}
+
+// Ge matches values greater than or equal to arg.
+func Ge(arg interface{}) *Predicate {
+	return comparison("Ge", arg, func(c int) bool { return c >= 0 })
+}
+
+// Lt matches values less than arg.
+func Lt(arg interface{}) *Predicate {
+	return comparison("Lt", arg, func(c int) bool { return c < 0 })
+}
+
+// Le matches values less than or equal to arg.
+func Le(arg interface{}) *Predicate {
+	return comparison("Le", arg, func(c int) bool { return c <= 0 })
+}
+
+// Between matches values from low to high inclusive.
+func Between(low, high interface{}) *Predicate {
+	ge, le := Ge(low), Le(high)
+	return &Predicate{
+		description: fmt.Sprintf("Between(%v, %v)", low, high),
+		test: func(value interface{}, b goshua.Bindings) bool {
+			return ge.test(value, b) && le.test(value, b)
+		},
+	}
+}
+
+// In matches values which are equal to an element of set, a slice or
+// array, or to a key of set, a map.
+func In(set interface{}) *Predicate {
+	s := reflect.ValueOf(set)
+	switch s.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+	default:
+		panic(fmt.Sprintf("In: %T is not a slice, array or map", set))
+	}
+	return &Predicate{
+		description: fmt.Sprintf("In(%v)", set),
+		test: func(value interface{}, b goshua.Bindings) bool {
+			if s.Kind() == reflect.Map {
+				key := reflect.ValueOf(value)
+				if hashable(key) && key.Type().AssignableTo(s.Type().Key()) {
+					if s.MapIndex(key).IsValid() {
+						return true
+					}
+					if key.Type() == s.Type().Key() && exactKey(key.Type()) {
+						// Only a key equal to value with ==
+						// is equal to it.
+						return false
+					}
+				}
+				iter := s.MapRange()
+				for iter.Next() {
+					if equalElement(value, iter.Key().Interface(), b) {
+						return true
+					}
+				}
+				return false
+			}
+			for i := 0; i < s.Len(); i++ {
+				if equalElement(value, s.Index(i).Interface(), b) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// equalElement returns true if value is equal to element, which is
+// looked up in b if it is a Variable.
+func equalElement(value, element interface{}, b goshua.Bindings) bool {
+	element, ok := valueOf(element, b)
+	if !ok {
+		return false
+	}
+	eq, err := goshua.EqualIn(b, value, element)
+	return err == nil && eq
+}
+
+// hashable returns true if v can be looked up as a map key: it is valid,
+// its type is comparable and the interface values in it don't hold
+// values that aren't.
+func hashable(v reflect.Value) bool {
+	if !v.IsValid() || !v.Type().Comparable() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// exactKey returns true if goshua.Equal is == for values of type t, a
+// bool, integer or string of a predeclared type.
+func exactKey(t reflect.Type) bool {
+	if t.PkgPath() != "" {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// Regexp matches strings that match the regular expression pattern.  It
+// panics if pattern doesn't compile.
+func Regexp(pattern string) *Predicate {
+	re := regexp.MustCompile(pattern)
+	return &Predicate{
+		description: fmt.Sprintf("Regexp(%q)", pattern),
+		test: func(value interface{}, b goshua.Bindings) bool {
+			s, ok := value.(string)
+			return ok && re.MatchString(s)
+		},
+	}
+}
+
+// Satisfies matches values for which test returns true.
+func Satisfies(test func(interface{}) bool) *Predicate {
+	return &Predicate{
+		description: "Satisfies(func)",
+		test: func(value interface{}, b goshua.Bindings) bool {
+			return test(value)
+		},
+	}
+}
+
+// BindIf matches the values p matches and binds v to them.
+func BindIf(v goshua.Variable, p *Predicate) *Predicate {
+	return &Predicate{
+		description: fmt.Sprintf("BindIf(%s, %s)", v.Name(), p),
+		test:        p.test,
+		bind:        v,
+	}
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than
+// b in the order of goshua.Compare.  Numbers of any type can be compared,
+// as can strings.  It returns false if a and b can't be compared, or if
+// either is NaN.
+func compare(a, b interface{}) (int, bool) {
+	v1, v2 := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case v1.Kind() == reflect.String && v2.Kind() == reflect.String:
+	case isNumber(v1) && isNumber(v2):
+		if isNaN(v1) || isNaN(v2) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	c, err := goshua.Compare(a, b)
+	switch {
+	case err != nil:
+		return 0, false
+	case c < 0:
+		return -1, true
+	case c > 0:
+		return 1, true
+	}
+	return 0, true
+}
+
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isNaN(v reflect.Value) bool {
+	return (v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64) && math.IsNaN(v.Float())
+}
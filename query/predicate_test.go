@@ -0,0 +1,182 @@
+package query
+
+import "math"
+import "reflect"
+import "testing"
+
+import "goshua/goshua"
+import "goshua/unification"
+
+type reading struct {
+	Sensor string
+	Value  float64
+	Count  int
+}
+
+func matches(t *testing.T, matcher interface{}, fact reading, b goshua.Bindings) (goshua.Bindings, bool) {
+	q := goshua.NewQuery(reflect.TypeOf(fact), nil, map[string]interface{}{
+		"Value": matcher,
+	})
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, fact, b, tc.Continuation)
+	if !tc.WasContinued() {
+		return nil, false
+	}
+	return tc.Bindings(), true
+}
+
+func TestPredicates(t *testing.T) {
+	scope := goshua.NewScope()
+	limit := scope.Lookup("limit")
+	b, _ := goshua.EmptyBindings().Bind(limit, 20)
+	for _, c := range []struct {
+		matcher interface{}
+		value   float64
+		want    bool
+	}{
+		{Gt(10), 10.5, true},
+		{Gt(10), 10, false},
+		{Ge(10), 10, true},
+		{Lt(uint8(3)), -1, true},
+		{Le(2.5), 2.5, true},
+		{Between(1, 3), 3, true},
+		{Between(1, 3), 3.1, false},
+		{Gt(limit), 21, true},
+		{Gt(limit), 19, false},
+		{Gt(scope.Lookup("unbound")), 19, false},
+		{Gt("a"), 1, false},
+		{In([]interface{}{1.5, 2.0}), 2, true},
+		{In([]float64{1.5, 2}), 3, false},
+		{In(map[float64]bool{1.5: true}), 1.5, true},
+		{In(map[int64]bool{2: true}), 2, true},
+		{In(map[interface{}]bool{int64(2): true}), 2.0, true},
+		{In(map[int]bool{2: true}), 3, false},
+		{In(map[string]bool{"a": true}), 1, false},
+		{Satisfies(func(v interface{}) bool { return v.(float64) < 0 }), -3, true},
+	} {
+		if _, ok := matches(t, c.matcher, reading{Value: c.value}, b); ok != c.want {
+			t.Errorf("%v against %v: got %v", c.matcher, c.value, ok)
+		}
+	}
+}
+
+// In looks for values that can't be map keys among the keys of a set
+// rather than panicking.
+func TestInUnhashable(t *testing.T) {
+	type labelled struct {
+		L interface{}
+	}
+	in := In(map[interface{}]bool{1: true, [2]int{1, 2}: true})
+	for _, c := range []struct {
+		value interface{}
+		want  bool
+	}{
+		{[]int{1}, false},
+		{[]int{1, 2}, true},
+		{labelled{[]int{1}}, false},
+		{map[string]int{}, false},
+	} {
+		if got := in.test(c.value, goshua.EmptyBindings()); got != c.want {
+			t.Errorf("%v against %#v: got %v", in, c.value, got)
+		}
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	q := goshua.NewQuery(reflect.TypeOf(reading{}), nil, map[string]interface{}{
+		"Sensor": Regexp("^foo"),
+		"Count":  Regexp("1"),
+	})
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, reading{Sensor: "foobar"}, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("Regexp should not match an int")
+	}
+	q = goshua.NewQuery(reflect.TypeOf(reading{}), nil, map[string]interface{}{
+		"Sensor": Regexp("^foo"),
+	})
+	for sensor, want := range map[string]bool{"foobar": true, "barfoo": false} {
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, reading{Sensor: sensor}, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() != want {
+			t.Errorf("Regexp(\"^foo\") against %q: got %v", sensor, !want)
+		}
+	}
+}
+
+func TestBindIf(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	b, ok := matches(t, BindIf(v, Between(0, 1)), reading{Value: 0.5}, goshua.EmptyBindings())
+	if !ok {
+		t.Fatalf("BindIf should have matched")
+	}
+	if val, ok := b.Get(v); !ok || val != 0.5 {
+		t.Errorf("v should be 0.5, not %#v", val)
+	}
+	if _, ok := matches(t, BindIf(v, Between(0, 1)), reading{Value: 2}, goshua.EmptyBindings()); ok {
+		t.Errorf("BindIf should not have matched")
+	}
+	// v already has a different value.
+	b, _ = goshua.EmptyBindings().Bind(v, 0.25)
+	if _, ok := matches(t, BindIf(v, Between(0, 1)), reading{Value: 0.5}, b); ok {
+		t.Errorf("BindIf should not rebind v")
+	}
+}
+
+func TestPredicateQueries(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	gt := Gt(3)
+	q1 := goshua.NewQuery(reflect.TypeOf(reading{}), nil, map[string]interface{}{
+		"Count": gt,
+	})
+	for _, c := range []struct {
+		matcher interface{}
+		b       goshua.Bindings
+		want    bool
+	}{
+		{gt, goshua.EmptyBindings(), true},
+		{4, goshua.EmptyBindings(), true},
+		{2, goshua.EmptyBindings(), false},
+		{v, goshua.EmptyBindings(), false},
+		{v, bind(v, 5), true},
+	} {
+		q2 := goshua.NewQuery(reflect.TypeOf(reading{}), nil, map[string]interface{}{
+			"Count": c.matcher,
+		})
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q1, q2, c.b, tc.Continuation)
+		if tc.WasContinued() != c.want {
+			t.Errorf("Gt(3) query against %v query: got %v", c.matcher, !c.want)
+		}
+	}
+}
+
+func bind(v goshua.Variable, value interface{}) goshua.Bindings {
+	b, _ := goshua.EmptyBindings().Bind(v, value)
+	return b
+}
+
+func TestCompare(t *testing.T) {
+	for _, c := range []struct {
+		a, b interface{}
+		want int
+		ok   bool
+	}{
+		{1, 2, -1, true},
+		{uint64(1 << 63), int64(-1), 1, true},
+		{int8(-1), uint(0), -1, true},
+		{int64(1<<53 + 1), float64(1 << 53), 1, true},
+		{2.5, 2, 1, true},
+		{"a", "b", -1, true},
+		{"a", 1, 0, false},
+		{nil, 1, 0, false},
+		{math.NaN(), 1, 0, false},
+		{float32(1.5), uint8(1), 1, true},
+	} {
+		if got, ok := compare(c.a, c.b); got != c.want || ok != c.ok {
+			t.Errorf("compare(%v, %v) = %d, %v", c.a, c.b, got, ok)
+		}
+	}
+}
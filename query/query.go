@@ -1,10 +1,15 @@
 // Package query provides a way to test some field values of a
 // structure while extracting others to variables during unification.
+//
+// Values are read by reader methods, by exported fields or by dotted
+// paths of them such as "Owner.Address.City".
 package query
 
-import "log"
 import "fmt"
+import "log"
 import "reflect"
+import "sort"
+import "strings"
 import "goshua/goshua"
 
 // query implements the Unifier interface to test and extract fields
@@ -12,53 +17,151 @@ import "goshua/goshua"
 type query struct {
 	structType reflect.Type
 	itself     goshua.Variable
-	// Map from a reader method name to an object to Unify the read value against.
+	// Map from a reader method name, field name or dotted path to an
+	// object to Unify the read value against.
 	matchers map[string]interface{}
-}
-
-func matchersKey(method reflect.Method) string {
-	// We don't need the package because name is confined to the context
+	// compiled holds the matchers, other than goshua.Any, prepared for
+	// unifying against facts.
+	compiled []compiled
+	// matchPointers is set if the query also matches pointers to values
 	// of structType.
-	return method.Name
+	matchPointers bool
 }
 
 // newQuery makes a Query for unifying against an object of a specified type.
-// readerValues is a map from the names pf reader methods on that obkect (as
-// strings) to values or variables to be unified against.
+// readerValues is a map from the names of reader methods or fields on that
+// object, or dotted paths of them, to values or variables to be unified
+// against.
 // If itself is provided that variable will be bound to the object itself
-// that the Query matched.
+// that the Query matched.  newQuery panics if New returns an error.
 func newQuery(t reflect.Type, itself goshua.Variable, readerValues map[string]interface{}) goshua.Query {
+	q, err := New(t, itself, readerValues)
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
+// New is like goshua.NewQuery except that it returns an *Error listing
+// every problem with readerValues rather than panicking.
+func New(t reflect.Type, itself goshua.Variable, readerValues map[string]interface{}) (goshua.Query, error) {
+	if t == nil {
+		return nil, &Error{Problems: []string{"no type"}}
+	}
 	q := query{
 		structType: t,
 		itself:     itself,
 		matchers:   make(map[string]interface{}),
 	}
+	var problems []string
 	for name, val := range readerValues {
-		if method, ok := t.MethodByName(name); ok {
-			q.matchers[matchersKey(method)] = val
-		} else {
-			/* Show available methods
-			for i := 0; i < t.NumMethod(); i++ {
-				m := t.Method(i)
-				log.Printf("%d: method %s.%s", i, m.PkgPath, m.Name)
-			}
-			*/
-			panic(fmt.Sprintf("No method %s for type %v", name, t))
+		if _, err := cachedPath(t, name); err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
+		q.matchers[name] = val
+	}
+	if problems != nil {
+		sort.Strings(problems)
+		return nil, &Error{Type: t, Problems: problems}
 	}
-	return &q
+	q.compile()
+	return &q, nil
 }
 
 func init() {
 	goshua.NewQuery = newQuery
+	goshua.MakeQuery = New
+}
+
+// Error lists the problems with the matchers of a query.
+type Error struct {
+	Type     reflect.Type
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	if e.Type == nil {
+		return "query: " + strings.Join(e.Problems, "; ")
+	}
+	return fmt.Sprintf("query on %v: %s", e.Type, strings.Join(e.Problems, "; "))
+}
+
+// Spec describes a query to be made by goshua.NewQuery.
+type Spec struct {
+	Type        reflect.Type
+	Itself      goshua.Variable
+	FieldValues map[string]interface{}
+}
+
+// Errors is returned by Validate.  It holds an *Error for each Spec that
+// has problems.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate checks that a query can be made from each of specs.  It can
+// be called from an init function to find problems with queries before
+// they are used.
+func Validate(specs ...Spec) error {
+	var errs Errors
+	for _, spec := range specs {
+		if _, err := New(spec.Type, spec.Itself, spec.FieldValues); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// MatchPointers returns a copy of q, which must have been made by
+// goshua.NewQuery, which also matches pointers to values of q's type.
+// A nil pointer doesn't match.
+func MatchPointers(q goshua.Query) goshua.Query {
+	q1 := *q.(*query)
+	q1.matchPointers = true
+	return &q1
+}
+
+// matchValue returns the value the matchers of q read from thing, or
+// false if thing is not of a type that q matches.  A query on an
+// interface type matches anything that implements it.
+func (q *query) matchValue(thing interface{}) (reflect.Value, bool) {
+	t := q.structType
+	v := reflect.ValueOf(thing)
+	if !v.IsValid() {
+		return v, false
+	}
+	switch thingType := v.Type(); {
+	case thingType == t:
+		return v, true
+	case t.Kind() == reflect.Interface:
+		return v, thingType.Implements(t)
+	case q.matchPointers && thingType.Kind() == reflect.Ptr && thingType.Elem() == t:
+		if v.IsNil() {
+			return v, false
+		}
+		return v.Elem(), true
+	}
+	// log.Printf("Types don't match: %v, %v", t, thingType)
+	return v, false
 }
 
 func (q *query) IsQuery() bool { return true }
 
 // Unify implements goshua.Unify for query.
-// query can unify against a struct of its specified type, or with another
-// query of the same specified struct type.  Keys in a query which do not
-// match a filed of that struct type are ignored.
+// query can unify against a struct of its specified type, or of a type
+// that implements it if it's an interface, or with another query of the
+// same specified struct type.  Keys in a query which do not
+// match a field of that struct type are ignored.
 func (q *query) Unify(thing interface{}, b goshua.Bindings, continuation func(goshua.Bindings)) {
 	t := q.structType
 	// query should also be able to unify against another query
@@ -67,18 +170,18 @@ func (q *query) Unify(thing interface{}, b goshua.Bindings, continuation func(go
 			// log.Printf("query types don't match %v %v", t, thingQ.structType)
 			return
 		}
-		for i := 0; i < t.NumMethod(); i++ {
-			method := t.Method(i)
-			i1, ok1 := q.matchers[matchersKey(method)]
-			i2, ok2 := thingQ.matchers[matchersKey(method)]
+		for _, name := range matcherNames(q.matchers, thingQ.matchers) {
+			i1, ok1 := q.matchers[name]
+			i2, ok2 := thingQ.matchers[name]
 			cont := false
-			if ok1 && ok2 {
-				goshua.Unify(i1, i2, b, func(b1 goshua.Bindings) {
-					b = b1
-					cont = true
-				})
+			if goshua.IsAny(i1) || goshua.IsAny(i2) {
+				// The anonymous variable matches whatever the other
+				// query has, even nothing.
+				continue
+			} else if ok1 && ok2 {
+				b, cont = unifyOnce(i1, i2, b)
 			} else if ok1 || ok2 {
-				log.Printf("%s matcher missing", method.Name)
+				log.Printf("%s matcher missing", name)
 				return
 			} else {
 				// Neither query cares about this value.
@@ -92,23 +195,27 @@ func (q *query) Unify(thing interface{}, b goshua.Bindings, continuation func(go
 		return
 	}
 	// Unifying the Query against a struct:
-	v := reflect.ValueOf(thing)
-	thingType := v.Type()
-	if t != thingType {
-		// log.Printf("Types don't match: %v, %v", t, thingType)
+	v, ok := q.matchValue(thing)
+	if !ok {
 		return
 	}
-	for name, val1 := range q.matchers {
-		method, _ := t.MethodByName(name)
-		val2 := method.Func.Call([]reflect.Value{v})[0].Interface()
-		cont := false
-		goshua.Unify(val1, val2, b,
-			func(b1 goshua.Bindings) {
-				b = b1
-				cont = true
-			})
+	for i := range q.compiled {
+		c := &q.compiled[i]
+		rv, ok := c.path.read(v)
+		if !ok {
+			// A nil pointer along the path.
+			return
+		}
+		if c.equal != nil {
+			if eq, ok := c.equal(rv); ok {
+				if !eq {
+					return
+				}
+				continue
+			}
+		}
 		// Field value didn't unify, so unification fails.
-		if !cont {
+		if b, ok = unifyOnce(c.value, valueInterface(rv), b); !ok {
 			// log.Printf("no match %s %v %v", method.Name, val1, val2)
 			return
 		}
@@ -123,3 +230,48 @@ func (q *query) Unify(thing interface{}, b goshua.Bindings, continuation func(go
 		}
 	}
 }
+
+// unifyOnce unifies thing1 and thing2 and returns the Bindings that the
+// continuation is called with, or the last of them if it is called more
+// than once.  Keeping the closure here means the caller's Bindings don't
+// escape to the heap.
+func unifyOnce(thing1, thing2 interface{}, b goshua.Bindings) (goshua.Bindings, bool) {
+	var result goshua.Bindings
+	ok := false
+	goshua.Unify(thing1, thing2, b, func(b1 goshua.Bindings) {
+		result = b1
+		ok = true
+	})
+	return result, ok
+}
+
+// Resolve implements goshua.Resolvable for query.  The matchers are
+// resolved.  itself is left as it is.
+func (q *query) Resolve(resolve func(interface{}) interface{}) interface{} {
+	q1 := &query{
+		structType:    q.structType,
+		itself:        q.itself,
+		matchers:      make(map[string]interface{}),
+		matchPointers: q.matchPointers,
+	}
+	for name, val := range q.matchers {
+		q1.matchers[name] = resolve(val)
+	}
+	q1.compile()
+	return q1
+}
+
+// matcherNames returns the names of the matchers of both queries in order.
+func matcherNames(m1, m2 map[string]interface{}) []string {
+	names := []string{}
+	for name := range m1 {
+		names = append(names, name)
+	}
+	for name := range m2 {
+		if _, ok := m1[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,13 +1,15 @@
 package query
 
 import "reflect"
+import "strings"
 import "testing"
+import "time"
 
 import "goshua/goshua"
 import "goshua/unification"
 import _ "goshua/variables"
 import _ "goshua/bindings"
-import _ "goshua/equality"
+import "goshua/equality"
 
 type testStruct struct {
 	a int
@@ -129,3 +131,469 @@ func TestUnifyQueryQueryFail(t *testing.T) {
 		t.Errorf("queries should not have unified")
 	}
 }
+
+func TestUnifyQueryAnonymous(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	o := &testStruct{
+		a: 3,
+		b: "foo",
+	}
+	q := goshua.NewQuery(reflect.TypeOf(o), goshua.Any, map[string]interface{}{
+		"A": goshua.Any,
+		"B": v,
+	})
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, o, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Failed to unify Query with goshua.Any and struct")
+	}
+	if val, ok := tc.Bindings().Get(v); !ok {
+		t.Errorf("Variable %s should have been bound", v.Name())
+	} else if eq, err := goshua.Equal(val, "foo"); err != nil || !eq {
+		t.Errorf("Variable bound to wrong value, got %#v.  %s", val, err)
+	}
+	// goshua.Any matches a matcher the other query doesn't have.
+	q2 := goshua.NewQuery(reflect.TypeOf(o), nil, map[string]interface{}{
+		"B": "foo",
+		"C": goshua.Any,
+	})
+	tc = unification.MakeTestContinuation(t)
+	goshua.Unify(q, q2, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Errorf("Failed to unify Queries with goshua.Any")
+	}
+}
+
+func TestResolveQuery(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	o := &testStruct{
+		a: 4,
+		b: "foo",
+	}
+	q := goshua.NewQuery(reflect.TypeOf(o), nil, map[string]interface{}{
+		"A": v,
+	})
+	b, _ := goshua.EmptyBindings().Bind(v, 4)
+	resolved, unbound := goshua.Resolve(q, b)
+	if len(unbound) != 0 {
+		t.Errorf("Resolve reported unbound variables %v", unbound)
+	}
+	q1 := resolved.(*query)
+	if q1 == q || q1.matchers["A"] != 4 {
+		t.Errorf("Resolve of query: got %#v", q1)
+	}
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(resolved, &testStruct{a: 5}, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("resolved query should not match a different value")
+	}
+}
+
+type address struct {
+	City string
+}
+
+type person struct {
+	Name    string
+	Address *address
+}
+
+func (p person) Initial() interface{} { return p.Name[:1] }
+
+type pet struct {
+	Name  string
+	Owner *person
+	Tags  interface{}
+}
+
+func TestUnifyQueryFields(t *testing.T) {
+	scope := goshua.NewScope()
+	city := scope.Lookup("city")
+	initial := scope.Lookup("initial")
+	o := &pet{
+		Name:  "Rex",
+		Owner: &person{Name: "Ann", Address: &address{City: "Oslo"}},
+		Tags:  person{Name: "Bo"},
+	}
+	q := goshua.NewQuery(reflect.TypeOf(o), nil, map[string]interface{}{
+		"Name":               "Rex",
+		"Owner.Address.City": city,
+		"Owner.Initial":      initial,
+		"Tags.Initial":       "B",
+	})
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, o, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Failed to unify Query with fields and struct")
+	}
+	if val, ok := tc.Bindings().Get(city); !ok || val != "Oslo" {
+		t.Errorf("city should be Oslo, not %#v", val)
+	}
+	if val, ok := tc.Bindings().Get(initial); !ok || val != "A" {
+		t.Errorf("initial should be A, not %#v", val)
+	}
+	// A nil pointer along a path fails the match.
+	for _, o := range []*pet{
+		{Name: "Rex", Tags: person{Name: "Bo"}},
+		{Name: "Rex", Owner: &person{Name: "Ann"}, Tags: person{Name: "Bo"}},
+		{Name: "Rex", Owner: &person{Name: "Ann", Address: &address{}}},
+	} {
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, o, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() {
+			t.Errorf("Query should not have unified with %#v", o)
+		}
+	}
+}
+
+func TestQueryUnknownField(t *testing.T) {
+	for _, name := range []string{"Nope", "Owner.Nope", "Owner.Name.Nope", "name"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewQuery should have panicked for %s", name)
+				}
+			}()
+			goshua.NewQuery(reflect.TypeOf(pet{}), nil, map[string]interface{}{
+				name: 1,
+			})
+		}()
+	}
+}
+
+type example struct {
+	Name    string
+	Age     int
+	Owner   *person
+	Nick    interface{}
+	Score   int `goshua:"?score"`
+	Ignored int `goshua:"-"`
+}
+
+func TestQueryFromExample(t *testing.T) {
+	scope := goshua.NewScope()
+	nick := scope.Lookup("nick")
+	q := goshua.QueryFromExample(example{
+		Name:    "Rex",
+		Owner:   &person{Address: &address{City: "Oslo"}},
+		Nick:    nick,
+		Ignored: 3,
+	}, scope)
+	if want := []string{"Name", "Nick", "Owner.Address.City", "Score"}; !reflect.DeepEqual(matcherNames(q.(*query).matchers, nil), want) {
+		t.Errorf("matchers should be %v, not %v", want, q.(*query).matchers)
+	}
+	fact := example{
+		Name:    "Rex",
+		Age:     7,
+		Owner:   &person{Name: "Ann", Address: &address{City: "Oslo"}},
+		Nick:    "R",
+		Score:   10,
+		Ignored: 4,
+	}
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Failed to unify query from example")
+	}
+	if val, ok := tc.Bindings().Get(nick); !ok || val != "R" {
+		t.Errorf("nick should be R, not %#v", val)
+	}
+	if val, ok := tc.Bindings().Get(scope.Lookup("score")); !ok || val != 10 {
+		t.Errorf("score should be 10, not %#v", val)
+	}
+	fact.Owner.Address.City = "Bergen"
+	tc = unification.MakeTestContinuation(t)
+	goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("query from example should not match a different city")
+	}
+}
+
+// caseless defines its own equality.
+type caseless struct {
+	S string
+}
+
+func (c caseless) GoshuaEqual(other interface{}) (bool, error) {
+	o, ok := other.(caseless)
+	return ok && strings.EqualFold(c.S, o.S), nil
+}
+
+// celsius has an equality function registered for it.
+type celsius struct {
+	Degrees float64
+}
+
+func init() {
+	t := reflect.TypeOf(celsius{})
+	equality.Register(t, t, func(a, b interface{}) (bool, error) {
+		return a.(celsius) == b.(celsius), nil
+	})
+}
+
+type appointment struct {
+	When  time.Time
+	Until *time.Time
+	Who   caseless
+	Temp  celsius
+	Where *address
+}
+
+// Structs without exported fields and values that define their own
+// equality are matched as a whole.
+func TestQueryFromExampleLeaves(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	until := when.Add(time.Hour)
+	q := goshua.QueryFromExample(&appointment{
+		When:  when,
+		Until: &until,
+		Who:   caseless{"Ann"},
+		Temp:  celsius{20},
+		Where: &address{City: "Oslo"},
+	}, nil)
+	if want := []string{"Temp", "Until", "When", "Where.City", "Who"}; !reflect.DeepEqual(matcherNames(q.(*query).matchers, nil), want) {
+		t.Errorf("matchers should be %v, not %v", want, q.(*query).matchers)
+	}
+	later := until
+	fact := &appointment{
+		When:  when,
+		Until: &later,
+		Who:   caseless{"ANN"},
+		Temp:  celsius{20},
+		Where: &address{City: "Oslo"},
+	}
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Failed to unify query from example")
+	}
+	fact.When = when.Add(time.Second)
+	tc = unification.MakeTestContinuation(t)
+	goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("query from example should not match a different time")
+	}
+}
+
+type tagged struct {
+	Tags  map[string]bool
+	Count *int
+	Extra interface{}
+}
+
+// Map and pointer fields of an example are matched with Equal.
+func TestQueryFromExampleMapsAndPointers(t *testing.T) {
+	count := 2
+	example := &tagged{
+		Tags:  map[string]bool{"a": true},
+		Count: &count,
+		Extra: map[string]int{"b": 1},
+	}
+	q := goshua.QueryFromExample(example, nil)
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, example, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("query from example should match the example")
+	}
+	other := 2
+	tc = unification.MakeTestContinuation(t)
+	goshua.Unify(q, &tagged{
+		Tags:  map[string]bool{"a": true},
+		Count: &other,
+		Extra: map[string]int{"b": 1},
+	}, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Errorf("query from example should match an equal fact")
+	}
+	other = 3
+	tc = unification.MakeTestContinuation(t)
+	goshua.Unify(q, &tagged{
+		Tags:  map[string]bool{"a": true},
+		Count: &other,
+		Extra: map[string]int{"b": 1},
+	}, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("query from example should not match a different count")
+	}
+}
+
+type named interface {
+	GetName() interface{}
+}
+
+type dog struct {
+	Name string
+}
+
+func (d dog) GetName() interface{} { return d.Name }
+
+type cat struct {
+	Name  string
+	Lives int
+}
+
+func (c *cat) GetName() interface{} { return c.Name }
+
+func TestUnifyQueryInterface(t *testing.T) {
+	scope := goshua.NewScope()
+	v := scope.Lookup("v")
+	q := goshua.NewQuery(reflect.TypeOf((*named)(nil)).Elem(), nil, map[string]interface{}{
+		"GetName": v,
+	})
+	for _, fact := range []interface{}{dog{"Rex"}, &dog{"Rex"}, &cat{Name: "Rex"}} {
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+		if !tc.WasContinued() {
+			t.Errorf("query on interface should match %#v", fact)
+		} else if val, ok := tc.Bindings().Get(v); !ok || val != "Rex" {
+			t.Errorf("v should be Rex, not %#v", val)
+		}
+	}
+	// cat's GetName has a pointer receiver so cat doesn't implement named.
+	for _, fact := range []interface{}{cat{Name: "Rex"}, "Rex", nil} {
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() {
+			t.Errorf("query on interface should not match %#v", fact)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewQuery should panic for a method the interface doesn't have")
+		}
+	}()
+	goshua.NewQuery(reflect.TypeOf((*named)(nil)).Elem(), nil, map[string]interface{}{
+		"Name": v,
+	})
+}
+
+func TestMatchPointers(t *testing.T) {
+	q := goshua.NewQuery(reflect.TypeOf(cat{}), nil, map[string]interface{}{
+		"Name":  "Tom",
+		"Lives": Gt(3),
+	})
+	tc := unification.MakeTestContinuation(t)
+	goshua.Unify(q, &cat{Name: "Tom", Lives: 9}, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("query on cat should not match *cat")
+	}
+	q = MatchPointers(q)
+	for fact, want := range map[interface{}]bool{
+		&cat{Name: "Tom", Lives: 9}: true,
+		cat{Name: "Tom", Lives: 9}:  true,
+		&cat{Name: "Tom", Lives: 1}: false,
+		(*cat)(nil):                 false,
+	} {
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() != want {
+			t.Errorf("MatchPointers query against %#v: got %v", fact, !want)
+		}
+	}
+}
+
+type badMethods struct {
+	Field  int
+	hidden int
+}
+
+func (badMethods) Two() (int, int)     { return 1, 2 }
+func (badMethods) None()               {}
+func (badMethods) Arg(int) interface{} { return nil }
+
+func TestMakeQueryErrors(t *testing.T) {
+	_, err := goshua.MakeQuery(reflect.TypeOf(badMethods{}), nil, map[string]interface{}{
+		"Field":  1,
+		"Nope":   1,
+		"Two":    1,
+		"None":   1,
+		"Arg":    1,
+		"hidden": 1,
+		"Field.": 1,
+	})
+	qerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("MakeQuery should have returned an *Error, not %v", err)
+	}
+	want := []string{
+		`"Field." has an empty name`,
+		"Arg: method Arg of query.badMethods takes arguments",
+		"None: method None of query.badMethods returns 0 values",
+		"Nope: no method or field Nope for type query.badMethods",
+		"Two: method Two of query.badMethods returns 2 values",
+		"hidden: hidden is unexported",
+	}
+	if !reflect.DeepEqual(qerr.Problems, want) {
+		t.Errorf("wrong problems:\n%s", strings.Join(qerr.Problems, "\n"))
+	}
+	if q, err := goshua.MakeQuery(reflect.TypeOf(badMethods{}), nil, map[string]interface{}{
+		"Field": 1,
+	}); err != nil || q == nil {
+		t.Errorf("MakeQuery failed: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate(
+		Spec{Type: reflect.TypeOf(pet{}), FieldValues: map[string]interface{}{"Owner.Name": 1}},
+		Spec{Type: reflect.TypeOf(pet{}), FieldValues: map[string]interface{}{"Owner.Age": 1}},
+		Spec{Type: reflect.TypeOf(cat{}), FieldValues: map[string]interface{}{"Age": 1}},
+		Spec{FieldValues: map[string]interface{}{"Age": 1}},
+	)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("Validate should have found three problems, not %v", err)
+	}
+	if err := Validate(Spec{Type: reflect.TypeOf(pet{}), FieldValues: map[string]interface{}{"Owner.Name": 1}}); err != nil {
+		t.Errorf("Validate: %s", err)
+	}
+}
+
+type typed struct {
+	Small int8
+	Named kind
+	Any   interface{}
+}
+
+type kind string
+
+func TestFastEqual(t *testing.T) {
+	fact := typed{Small: 7, Named: "k", Any: 7}
+	for _, c := range []struct {
+		name  string
+		value interface{}
+		want  bool
+	}{
+		{"Small", int8(7), true},
+		{"Small", int8(8), false},
+		// Not the type of the field, so goshua.Unify decides.
+		{"Small", 7, true},
+		{"Named", kind("k"), true},
+		{"Named", kind("j"), false},
+		{"Any", 7, true},
+		{"Any", 8, false},
+		{"Any", "7", false},
+	} {
+		q := goshua.NewQuery(reflect.TypeOf(fact), nil, map[string]interface{}{
+			c.name: c.value,
+		})
+		tc := unification.MakeTestContinuation(t)
+		goshua.Unify(q, fact, goshua.EmptyBindings(), tc.Continuation)
+		if tc.WasContinued() != c.want {
+			t.Errorf("%s: %#v against %#v: got %v", c.name, c.value, fact, !c.want)
+		}
+	}
+	if fastEqual(kind("k")) != nil || fastEqual(1.5) != nil || fastEqual(goshua.Any) != nil {
+		t.Errorf("fastEqual should only be used for predeclared types")
+	}
+}
+
+func TestPathCache(t *testing.T) {
+	q1 := goshua.NewQuery(reflect.TypeOf(pet{}), nil, map[string]interface{}{"Owner.Name": 1}).(*query)
+	q2 := goshua.NewQuery(reflect.TypeOf(pet{}), nil, map[string]interface{}{"Owner.Name": 2}).(*query)
+	if &q1.compiled[0].path[0] != &q2.compiled[0].path[0] {
+		t.Errorf("queries should share cached paths")
+	}
+}
@@ -0,0 +1,250 @@
+package reader
+
+import "bytes"
+import "fmt"
+import "io"
+import "math"
+import "reflect"
+import "sort"
+import "strconv"
+import "goshua/goshua"
+
+// Print writes term to w in the syntax that Read accepts.
+// An error is returned if term contains something that can't be
+// represented in that syntax, like a struct of an unregistered type or
+// a cycle.
+func Print(w io.Writer, term interface{}) error {
+	p := &printer{
+		w:        w,
+		visiting: make(map[visit]bool),
+	}
+	p.print(term, nil)
+	return p.err
+}
+
+// Sprint returns the textual representation of term.
+func Sprint(term interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := Print(&buf, term); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+	// visiting holds the pointers, maps and slices that are being
+	// printed, to catch cycles.
+	visiting map[visit]bool
+}
+
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func (p *printer) write(s string) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = io.WriteString(p.w, s)
+}
+
+func (p *printer) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+// print writes term.  implicit is the type that Read will convert term
+// to, if there is one, because it is in a struct field of that type.
+func (p *printer) print(term interface{}, implicit reflect.Type) {
+	if p.err != nil {
+		return
+	}
+	if term == nil {
+		p.write("nil")
+		return
+	}
+	if v, ok := term.(goshua.Variable); ok {
+		p.write("?" + v.Name())
+		return
+	}
+	if implicit != nil && implicit.Kind() == reflect.Interface {
+		implicit = nil
+	}
+	p.printValue(reflect.ValueOf(term), implicit)
+}
+
+// enter notes that v, a pointer, map or slice, is being printed.  It
+// returns false if it already is, because v contains itself.
+func (p *printer) enter(v reflect.Value) bool {
+	key := visit{v.Pointer(), v.Type()}
+	if p.visiting[key] {
+		p.fail("can't print cyclic %v", v.Type())
+		return false
+	}
+	p.visiting[key] = true
+	return true
+}
+
+func (p *printer) leave(v reflect.Value) {
+	delete(p.visiting, visit{v.Pointer(), v.Type()})
+}
+
+// elem returns the implicit type of the elements of a value whose
+// implicit type is t.
+func elem(t reflect.Type) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return t.Elem()
+	}
+	return nil
+}
+
+// writeNumber writes the number v, formatted as s.  Unless Read would
+// give it its type anyway, it is written as a conversion to that type.
+func (p *printer) writeNumber(v reflect.Value, implicit reflect.Type, s string) {
+	switch {
+	case implicit != nil && implicit.Kind() == v.Kind(),
+		v.Kind() == reflect.Int, v.Kind() == reflect.Float64:
+		p.write(s)
+	default:
+		p.write(v.Kind().String() + "(" + s + ")")
+	}
+}
+
+func (p *printer) printValue(v reflect.Value, implicit reflect.Type) {
+	switch v.Kind() {
+	case reflect.Bool:
+		p.write(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.writeNumber(v, implicit, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		p.writeNumber(v, implicit, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		var s string
+		switch {
+		case math.IsNaN(f):
+			s = "NaN"
+		case math.IsInf(f, 1):
+			s = "Inf"
+		case math.IsInf(f, -1):
+			s = "-Inf"
+		default:
+			s = strconv.FormatFloat(f, 'g', -1, v.Type().Bits())
+			if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+				// Make sure it reads back as a float.
+				s += ".0"
+			}
+		}
+		p.writeNumber(v, implicit, s)
+	case reflect.String:
+		p.write(strconv.Quote(v.String()))
+	case reflect.Interface:
+		if v.IsNil() {
+			p.write("nil")
+		} else {
+			p.print(v.Elem().Interface(), nil)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				p.write("nil")
+				return
+			}
+			if v.Len() > 0 {
+				if !p.enter(v) {
+					return
+				}
+				defer p.leave(v)
+			}
+		}
+		p.write("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.print(v.Index(i).Interface(), elem(implicit))
+		}
+		p.write("]")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			p.fail("can't print map with %v keys", v.Type().Key())
+			return
+		}
+		if v.IsNil() {
+			p.write("nil")
+			return
+		}
+		if !p.enter(v) {
+			return
+		}
+		defer p.leave(v)
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		p.write("{")
+		for i, k := range keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(strconv.Quote(k.String()))
+			p.write(": ")
+			p.print(v.MapIndex(k).Interface(), elem(implicit))
+		}
+		p.write("}")
+	case reflect.Ptr:
+		if v.IsNil() {
+			p.write("nil")
+			return
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			p.fail("can't print pointer %v", v.Type())
+			return
+		}
+		if !p.enter(v) {
+			return
+		}
+		defer p.leave(v)
+		p.write("&")
+		p.printStruct(v.Elem())
+	case reflect.Struct:
+		p.printStruct(v)
+	default:
+		p.fail("can't print %v", v.Type())
+	}
+}
+
+func (p *printer) printStruct(v reflect.Value) {
+	t := v.Type()
+	name, ok := typeNames[t]
+	if !ok {
+		p.fail("type %v is not registered", t)
+		return
+	}
+	p.write(name)
+	p.write("{")
+	first := true
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// Unexported fields can't be read back in.
+			continue
+		}
+		if !first {
+			p.write(", ")
+		}
+		first = false
+		p.write(sf.Name)
+		p.write(": ")
+		p.print(v.Field(i).Interface(), sf.Type)
+	}
+	p.write("}")
+}
@@ -0,0 +1,480 @@
+// Package reader implements a compact textual syntax for terms that
+// contain logic variables.
+//
+// The syntax is
+//
+//	?x                       a logic variable, looked up in a goshua.Scope
+//	?_                       the anonymous variable goshua.Any
+//	"foo" `bar`              strings
+//	12 -3 0x1f 2.5 1e6       numbers: integers read as int, others as float64
+//	NaN Inf -Inf             the float64 special values
+//	int8(3) float32(2.5)     a number of another numeric type
+//	true false nil
+//	[a, b, c]                a list, read as []interface{}
+//	{"key": value, key: v}   a map with string keys, read as map[string]interface{}
+//	Name{Field: value}       a struct literal of a type registered as Name
+//	&Name{Field: value}      a pointer to such a struct
+//
+// Comments are as in Go.  Commas are optional after the last element of
+// a list, map or struct literal.
+package reader
+
+import "fmt"
+import "io"
+import "math"
+import "reflect"
+import "strconv"
+import "strings"
+import "text/scanner"
+import "goshua/goshua"
+
+// registry maps the names used in struct literals to struct types.
+var registry = make(map[string]reflect.Type)
+
+// typeNames is the inverse of registry.  It is used by the printer.
+var typeNames = make(map[reflect.Type]string)
+
+// numberTypes are the types that numbers can be converted to.
+var numberTypes = map[string]reflect.Type{
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
+
+// RegisterType makes the struct type t available for struct literals
+// under the specified name.  It is typically called from an init
+// function.
+func RegisterType(name string, t reflect.Type) {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("reader.RegisterType: %v is not a struct type", t))
+	}
+	if old, ok := registry[name]; ok && old != t {
+		panic(fmt.Sprintf("reader.RegisterType: %s is already registered for %v", name, old))
+	}
+	registry[name] = t
+	typeNames[t] = name
+}
+
+// Reader reads terms from an io.Reader.
+type Reader struct {
+	scanner scanner.Scanner
+	scope   goshua.Scope
+	// tok is the current token.
+	tok rune
+	// errors collects any errors reported by scanner.
+	errors []string
+}
+
+// NewReader returns a Reader that reads terms from in.  Any logic
+// variables that are read are looked up in scope.
+func NewReader(in io.Reader, scope goshua.Scope) *Reader {
+	r := &Reader{scope: scope}
+	r.scanner.Init(in)
+	r.scanner.Mode = scanner.GoTokens
+	r.scanner.Error = func(s *scanner.Scanner, msg string) {
+		r.errors = append(r.errors, fmt.Sprintf("%s: %s", s.Position, msg))
+	}
+	r.next()
+	return r
+}
+
+// ReadString reads the single term in s.
+func ReadString(s string, scope goshua.Scope) (interface{}, error) {
+	r := NewReader(strings.NewReader(s), scope)
+	term, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.tok != scanner.EOF {
+		return nil, r.errorf("unexpected %s after term", r.scanner.TokenText())
+	}
+	return term, nil
+}
+
+// Read reads the next term.  It returns io.EOF if there are no more
+// terms to read.
+func (r *Reader) Read() (term interface{}, err error) {
+	if r.tok == scanner.EOF {
+		if err := r.scanError(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	// Parse errors are reported by panicking with a *syntaxError.
+	defer func() {
+		if e := recover(); e != nil {
+			se, ok := e.(*syntaxError)
+			if !ok {
+				panic(e)
+			}
+			term, err = nil, se
+		}
+	}()
+	term = r.term()
+	if err := r.scanError(); err != nil {
+		return nil, err
+	}
+	return term, nil
+}
+
+// syntaxError is the type of error returned by Read for malformed input.
+type syntaxError struct {
+	pos scanner.Position
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.pos, e.msg)
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) *syntaxError {
+	return &syntaxError{
+		pos: r.scanner.Position,
+		msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (r *Reader) fail(format string, args ...interface{}) {
+	panic(r.errorf(format, args...))
+}
+
+func (r *Reader) scanError() error {
+	if len(r.errors) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%s", strings.Join(r.errors, "; "))
+	r.errors = nil
+	return err
+}
+
+func (r *Reader) next() {
+	r.tok = r.scanner.Scan()
+}
+
+func (r *Reader) expect(tok rune) {
+	if r.tok != tok {
+		r.fail("expected %s, got %s", scanner.TokenString(tok),
+			scanner.TokenString(r.tok))
+	}
+	r.next()
+}
+
+// endOfSequence consumes an optional comma after a sequence element
+// and returns true if close is next.
+func (r *Reader) endOfSequence(close rune) bool {
+	if r.tok == close {
+		return true
+	}
+	r.expect(',')
+	return r.tok == close
+}
+
+func (r *Reader) term() interface{} {
+	switch r.tok {
+	case '?':
+		r.next()
+		if r.tok != scanner.Ident {
+			r.fail("expected variable name after ?")
+		}
+		name := r.scanner.TokenText()
+		r.next()
+		return r.scope.Lookup(name)
+	case scanner.String, scanner.RawString:
+		s, err := strconv.Unquote(r.scanner.TokenText())
+		if err != nil {
+			r.fail("%s", err)
+		}
+		r.next()
+		return s
+	case '-':
+		r.next()
+		if r.tok == scanner.Ident && r.scanner.TokenText() == "Inf" {
+			r.next()
+			return math.Inf(-1)
+		}
+		if r.tok != scanner.Int && r.tok != scanner.Float {
+			r.fail("expected number after -")
+		}
+		return r.number("-")
+	case scanner.Int, scanner.Float:
+		return r.number("")
+	case '[':
+		return r.list()
+	case '{':
+		return r.mapLiteral()
+	case '&':
+		r.next()
+		if r.tok != scanner.Ident {
+			r.fail("expected type name after &")
+		}
+		return r.structLiteral().Addr().Interface()
+	case scanner.Ident:
+		switch r.scanner.TokenText() {
+		case "true":
+			r.next()
+			return true
+		case "false":
+			r.next()
+			return false
+		case "nil":
+			r.next()
+			return nil
+		case "NaN":
+			r.next()
+			return math.NaN()
+		case "Inf":
+			r.next()
+			return math.Inf(1)
+		}
+		if t, ok := numberTypes[r.scanner.TokenText()]; ok {
+			return r.conversion(t)
+		}
+		return r.structLiteral().Interface()
+	case scanner.EOF:
+		r.fail("unexpected end of input")
+	}
+	r.fail("unexpected %s", r.scanner.TokenText())
+	return nil
+}
+
+func (r *Reader) number(sign string) interface{} {
+	text := sign + r.scanner.TokenText()
+	tok := r.tok
+	r.next()
+	if tok == scanner.Float {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			r.fail("%s", err)
+		}
+		return f
+	}
+	if i, err := strconv.ParseInt(text, 0, 0); err == nil {
+		return int(i)
+	}
+	if sign == "" {
+		if u, err := strconv.ParseUint(text, 0, 64); err == nil {
+			return u
+		}
+	}
+	r.fail("integer %s out of range", text)
+	return nil
+}
+
+// conversion reads a number converted to the numeric type t, as in
+// int8(3).
+func (r *Reader) conversion(t reflect.Type) interface{} {
+	r.next()
+	r.expect('(')
+	n := r.term()
+	switch n.(type) {
+	case int, uint64, float64:
+	default:
+		r.fail("expected number in %v conversion", t)
+	}
+	v, err := convert(n, t)
+	if err != nil {
+		r.fail("%s", err)
+	}
+	r.expect(')')
+	return v.Interface()
+}
+
+func (r *Reader) list() interface{} {
+	r.expect('[')
+	l := []interface{}{}
+	for r.tok != ']' {
+		l = append(l, r.term())
+		if r.endOfSequence(']') {
+			break
+		}
+	}
+	r.next()
+	return l
+}
+
+// key reads a map key or struct field name followed by a colon.
+func (r *Reader) key() string {
+	var key string
+	switch r.tok {
+	case scanner.Ident:
+		key = r.scanner.TokenText()
+	case scanner.String, scanner.RawString:
+		var err error
+		key, err = strconv.Unquote(r.scanner.TokenText())
+		if err != nil {
+			r.fail("%s", err)
+		}
+	default:
+		r.fail("expected key, got %s", r.scanner.TokenText())
+	}
+	r.next()
+	r.expect(':')
+	return key
+}
+
+func (r *Reader) mapLiteral() interface{} {
+	r.expect('{')
+	m := make(map[string]interface{})
+	for r.tok != '}' {
+		key := r.key()
+		if _, ok := m[key]; ok {
+			r.fail("duplicate key %q", key)
+		}
+		m[key] = r.term()
+		if r.endOfSequence('}') {
+			break
+		}
+	}
+	r.next()
+	return m
+}
+
+// typeName reads a possibly qualified type name such as example.Thing.
+func (r *Reader) typeName() string {
+	name := r.scanner.TokenText()
+	r.next()
+	for r.tok == '.' {
+		r.next()
+		if r.tok != scanner.Ident {
+			r.fail("malformed type name %s.", name)
+		}
+		name += "." + r.scanner.TokenText()
+		r.next()
+	}
+	return name
+}
+
+// structLiteral returns an addressable reflect.Value for the struct
+// literal being read.
+func (r *Reader) structLiteral() reflect.Value {
+	name := r.typeName()
+	t, ok := registry[name]
+	if !ok {
+		r.fail("unknown type %s", name)
+	}
+	v := reflect.New(t).Elem()
+	r.expect('{')
+	seen := make(map[string]bool)
+	for r.tok != '}' {
+		fieldName := r.key()
+		if seen[fieldName] {
+			r.fail("duplicate field %s", fieldName)
+		}
+		seen[fieldName] = true
+		sf, ok := t.FieldByName(fieldName)
+		if !ok || sf.PkgPath != "" {
+			r.fail("%s has no exported field %s", name, fieldName)
+		}
+		value := r.term()
+		fv, err := convert(value, sf.Type)
+		if err != nil {
+			r.fail("field %s.%s: %s", name, fieldName, err)
+		}
+		v.FieldByIndex(sf.Index).Set(fv)
+		if r.endOfSequence('}') {
+			break
+		}
+	}
+	r.next()
+	return v
+}
+
+// convert returns a reflect.Value of type t for a term that was read.
+// Lists and maps are converted element by element and numbers are
+// converted if they fit.
+func convert(term interface{}, t reflect.Type) (reflect.Value, error) {
+	if term == nil {
+		return reflect.Zero(t), nil
+	}
+	v := reflect.ValueOf(term)
+	if v.Type().AssignableTo(t) {
+		v1 := reflect.New(t).Elem()
+		v1.Set(v)
+		return v1, nil
+	}
+	if _, ok := term.(goshua.Variable); ok {
+		return reflect.Value{}, fmt.Errorf("can't store variable %v in a %v", term, t)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Kind() == reflect.Int {
+			v1 := reflect.New(t).Elem()
+			if v1.OverflowInt(v.Int()) {
+				return reflect.Value{}, fmt.Errorf("%v overflows %v", term, t)
+			}
+			v1.SetInt(v.Int())
+			return v1, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Kind() == reflect.Int && v.Int() >= 0 {
+			v1 := reflect.New(t).Elem()
+			if v1.OverflowUint(uint64(v.Int())) {
+				return reflect.Value{}, fmt.Errorf("%v overflows %v", term, t)
+			}
+			v1.SetUint(uint64(v.Int()))
+			return v1, nil
+		}
+		if v.Kind() == reflect.Uint64 {
+			v1 := reflect.New(t).Elem()
+			if v1.OverflowUint(v.Uint()) {
+				return reflect.Value{}, fmt.Errorf("%v overflows %v", term, t)
+			}
+			v1.SetUint(v.Uint())
+			return v1, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Float64, reflect.Int:
+			return v.Convert(t), nil
+		}
+	case reflect.Slice:
+		if l, ok := term.([]interface{}); ok {
+			s := reflect.MakeSlice(t, len(l), len(l))
+			for i, elt := range l {
+				ev, err := convert(elt, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("[%d]: %s", i, err)
+				}
+				s.Index(i).Set(ev)
+			}
+			return s, nil
+		}
+	case reflect.Array:
+		if l, ok := term.([]interface{}); ok && len(l) == t.Len() {
+			a := reflect.New(t).Elem()
+			for i, elt := range l {
+				ev, err := convert(elt, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("[%d]: %s", i, err)
+				}
+				a.Index(i).Set(ev)
+			}
+			return a, nil
+		}
+	case reflect.Map:
+		if m, ok := term.(map[string]interface{}); ok && t.Key().Kind() == reflect.String {
+			m1 := reflect.MakeMapWithSize(t, len(m))
+			for k, elt := range m {
+				ev, err := convert(elt, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("[%q]: %s", k, err)
+				}
+				m1.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+			}
+			return m1, nil
+		}
+	}
+	if v.Kind() == t.Kind() && v.Type().ConvertibleTo(t) {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("can't use %T as %v", term, t)
+}
@@ -0,0 +1,245 @@
+package reader
+
+import "io"
+import "math"
+import "reflect"
+import "strings"
+import "testing"
+import "goshua/goshua"
+import _ "goshua/variables"
+
+type testThing struct {
+	Name  string
+	Count int8
+	Tags  []string
+	Value interface{}
+	Next  *testThing
+	hide  int
+}
+
+func init() {
+	RegisterType("thing", reflect.TypeOf(testThing{}))
+}
+
+func TestReadAtoms(t *testing.T) {
+	scope := goshua.NewScope()
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{`"foo"`, "foo"},
+		{"`raw`", "raw"},
+		{`12`, 12},
+		{`-12`, -12},
+		{`0x1f`, 31},
+		{`2.5`, 2.5},
+		{`-1e3`, -1000.0},
+		{`true`, true},
+		{`false`, false},
+		{`nil`, nil},
+		{`[]`, []interface{}{}},
+		{`[1, "two", [3]]`, []interface{}{1, "two", []interface{}{3}}},
+		{`{a: 1, "b c": [2,],}`, map[string]interface{}{
+			"a":   1,
+			"b c": []interface{}{2},
+		}},
+	}
+	for _, test := range tests {
+		got, err := ReadString(test.in, scope)
+		if err != nil {
+			t.Errorf("ReadString(%q): %s", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ReadString(%q): got %#v, want %#v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestReadVariables(t *testing.T) {
+	scope := goshua.NewScope()
+	got, err := ReadString(`[?x, ?y, ?x]`, scope)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	l := got.([]interface{})
+	x := scope.Lookup("x")
+	if !x.SameAs(l[0].(goshua.Variable)) || !x.SameAs(l[2].(goshua.Variable)) {
+		t.Errorf("?x should be the Variable from scope: %v", l)
+	}
+	if !scope.Lookup("y").SameAs(l[1].(goshua.Variable)) {
+		t.Errorf("?y should be the Variable from scope: %v", l)
+	}
+}
+
+func TestReadStruct(t *testing.T) {
+	scope := goshua.NewScope()
+	got, err := ReadString(`&thing{Name: "a", Count: 3, Tags: ["x", "y"],
+		Value: ?v, Next: &thing{Name: "b"}}`, scope)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	want := &testThing{
+		Name:  "a",
+		Count: 3,
+		Tags:  []string{"x", "y"},
+		Value: scope.Lookup("v"),
+		Next:  &testThing{Name: "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	scope := goshua.NewScope()
+	for _, in := range []string{
+		``,
+		`[1, 2`,
+		`{1: 2}`,
+		`{a: 1, a: 2}`,
+		`? 3`,
+		`unknown{}`,
+		`thing{hide: 1}`,
+		`thing{Count: 1000}`,
+		`thing{Count: ?x}`,
+		`thing{Missing: 1}`,
+		`int8(1000)`,
+		`int8("a")`,
+		`uint(-1)`,
+		`-NaN`,
+		`1 2`,
+	} {
+		if got, err := ReadString(in, scope); err == nil {
+			t.Errorf("ReadString(%q) should have failed, got %#v", in, got)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	scope := goshua.NewScope()
+	r := NewReader(strings.NewReader(`1 // one
+		"two" /* two */ [3]`), scope)
+	var got []interface{}
+	for {
+		term, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		got = append(got, term)
+	}
+	want := []interface{}{1, "two", []interface{}{3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	scope := goshua.NewScope()
+	for _, in := range []string{
+		`"a \"quoted\" string"`,
+		`[1, -2, 3.5, 4.0, true, nil]`,
+		`{"a": ?x, "b": [?x, ?y]}`,
+		`[?_, ?x, ?_]`,
+		`&thing{Name: "a", Count: 3, Tags: ["x"], Value: ?v, Next: nil}`,
+		`thing{Name: "", Count: 0, Tags: nil, Value: {"k": ?v}, Next: &thing{Name: "n", Count: 1, Tags: nil, Value: nil, Next: nil}}`,
+	} {
+		term, err := ReadString(in, scope)
+		if err != nil {
+			t.Errorf("ReadString(%q): %s", in, err)
+			continue
+		}
+		printed, err := Sprint(term)
+		if err != nil {
+			t.Errorf("Sprint(%#v): %s", term, err)
+			continue
+		}
+		if printed != in {
+			t.Errorf("round trip: got %s, want %s", printed, in)
+		}
+		again, err := ReadString(printed, scope)
+		if err != nil {
+			t.Errorf("ReadString(%q): %s", printed, err)
+			continue
+		}
+		if !reflect.DeepEqual(term, again) {
+			t.Errorf("round trip: got %#v, want %#v", again, term)
+		}
+	}
+}
+
+func TestPrintErrors(t *testing.T) {
+	for _, term := range []interface{}{
+		struct{ A int }{1},
+		map[int]string{1: "one"},
+		make(chan int),
+	} {
+		if s, err := Sprint(term); err == nil {
+			t.Errorf("Sprint(%#v) should have failed, got %s", term, s)
+		}
+	}
+}
+
+func TestRoundTripNumbers(t *testing.T) {
+	scope := goshua.NewScope()
+	for _, term := range []interface{}{
+		int8(-3), int16(4), int32(5), int64(-6),
+		uint(7), uint8(8), uint16(9), uint32(10), uint64(1<<64 - 1),
+		float32(1.5), float32(2), 2.5, 3.0,
+		[]interface{}{int64(1), []interface{}{uint8(2)}},
+		&testThing{Count: 3, Value: int8(3)},
+		math.Inf(1), math.Inf(-1), float32(math.Inf(-1)),
+	} {
+		printed, err := Sprint(term)
+		if err != nil {
+			t.Errorf("Sprint(%#v): %s", term, err)
+			continue
+		}
+		again, err := ReadString(printed, scope)
+		if err != nil {
+			t.Errorf("ReadString(%q): %s", printed, err)
+			continue
+		}
+		if !reflect.DeepEqual(term, again) {
+			t.Errorf("round trip of %s: got %#v, want %#v", printed, again, term)
+		}
+	}
+	for _, term := range []interface{}{math.NaN(), float32(math.NaN())} {
+		printed, err := Sprint(term)
+		if err != nil {
+			t.Errorf("Sprint(%#v): %s", term, err)
+			continue
+		}
+		again, err := ReadString(printed, scope)
+		if err != nil {
+			t.Errorf("ReadString(%q): %s", printed, err)
+			continue
+		}
+		if reflect.TypeOf(again) != reflect.TypeOf(term) ||
+			!math.IsNaN(reflect.ValueOf(again).Float()) {
+			t.Errorf("round trip of %s: got %#v, want %#v", printed, again, term)
+		}
+	}
+}
+
+func TestPrintCycles(t *testing.T) {
+	thing := &testThing{Name: "a"}
+	thing.Next = thing
+	list := []interface{}{1, nil}
+	list[1] = list
+	m := map[string]interface{}{}
+	m["m"] = m
+	for _, term := range []interface{}{thing, list, m} {
+		if s, err := Sprint(term); err == nil {
+			t.Errorf("Sprint of a cyclic %T should have failed, got %s", term, s)
+		}
+	}
+	// Shared structure that isn't cyclic can be printed.
+	shared := &testThing{Name: "shared"}
+	if _, err := Sprint([]interface{}{shared, shared}); err != nil {
+		t.Errorf("Sprint of shared structure: %s", err)
+	}
+}
@@ -1,18 +1,26 @@
 package example
 
+import "fmt"
 import "reflect"
 import "testing"
 import "goshua/rete"
 
 
-func TestRete(t *testing.T) {
+// makeRete returns a rete with all of the example rules installed, and
+// the buffer of the thing3s they conclude.  If there are tests, the
+// thing3 rule joins its parameters with them.
+func makeRete(tests ...*rete.JoinTest) (rete.Node, rete.AbstractBufferNode) {
 	root := rete.MakeRootNode()
 	var thing3_rule rete.Rule
 	for _, rule := range rete.AllRules {
-		rule.Installer()(root)
 		if rule.Name() == "thing3" {
 			thing3_rule = rule
+			if len(tests) > 0 {
+				rete.InstallRule(root, rete.WithJoinTests(rule, tests...))
+				continue
+			}
 		}
+		rule.Installer()(root)
 	}
 	for _, typ := range thing3_rule.EmitTypes() {
 		rete.GetBuffered(rete.GetTypeTestNode(root, typ))
@@ -25,6 +33,11 @@ func TestRete(t *testing.T) {
 			}
 		}
 	})
+	return root, thing3_buffer
+}
+
+func TestRete(t *testing.T) {
+	root, thing3_buffer := makeRete()
 	// Validate
 	rete.Walk(root, func(n rete.Node) {
 		for _, err := range n.Validate() {
@@ -60,7 +73,8 @@ func TestRete(t *testing.T) {
 		dump()
 		t.Errorf("Wrong count for thing3 after first thing2 asserted: watd %d, got %d", want, got)
 	}
-	assert(&thing2{ Id: "c" })
+	c := &thing2{ Id: "c" }
+	assert(c)
 	if want, got := 2, thing3_buffer.Count(); want != got {
 		dump()
 		t.Errorf("Wrong count for thing3 after second thing2 asserted: want %d, got %d", want, got)		
@@ -70,4 +84,50 @@ func TestRete(t *testing.T) {
 		dump()
 		t.Errorf("Wrong count for thing3 after second thing1 asserted: want %d, got %d", want, got)		
 	}
+	// Retract stuff:
+	t.Logf("\nretracting %s\n", c)
+	root.Retract(c)
+	if want, got := 0, thing3_buffer.Count(); want != got {
+		dump()
+		t.Errorf("Wrong count for thing3 after second thing2 retracted: want %d, got %d", want, got)
+	}
+}
+
+// sameId joins a thing2 with the matches whose thing1 has its Id.
+var sameId = &rete.JoinTest{
+	Left: func(parameters []interface{}) interface{} {
+		return parameters[0].(*thing1).Id
+	},
+	Right: func(item interface{}) interface{} {
+		return item.(*thing2).Id
+	},
+}
+
+// BenchmarkThing3 asserts count thing1s and count thing2s, two for each
+// of the first count/2 thing1 Ids, and joins them by Id.  Each of those
+// thing1s makes two thing3s.  The JoinNodes look up matches by Id, so
+// the time grows linearly with count.
+func BenchmarkThing3(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("facts=%d", count), func(b *testing.B) {
+			t1s := make([]*thing1, count)
+			t2s := make([]*thing2, count)
+			for i := range t1s {
+				t1s[i] = &thing1{ Id: fmt.Sprint(i) }
+				t2s[i] = &thing2{ Id: fmt.Sprint(i / 2) }
+			}
+			for i := 0; i < b.N; i++ {
+				root, thing3_buffer := makeRete(sameId, sameId)
+				for _, t1 := range t1s {
+					root.Receive(t1)
+				}
+				for _, t2 := range t2s {
+					root.Receive(t2)
+				}
+				if want, got := count, thing3_buffer.Count(); want != got {
+					b.Fatalf("wrong count for thing3: want %d, got %d", want, got)
+				}
+			}
+		})
+	}
 }
@@ -0,0 +1,412 @@
+package rete
+
+import "fmt"
+import "strings"
+
+// token is a partial match of the parameters of a rule: an item for
+// each of its first length parameters.  A token shares the items of
+// the shorter match it extends.
+type token struct {
+	parent *token
+	item   interface{}
+	length int
+	// join is the JoinNode whose memory holds the token, and prev and
+	// next are its neighbours there.
+	join       *JoinNode
+	prev, next *token
+	// children are the tokens which extend this one.  siblingIndex is
+	// the token's index in the children of its parent.
+	children     []*token
+	siblingIndex int
+	// itemTokens holds the tokens which end with item.  itemIndex is
+	// the token's index there.
+	itemTokens *itemTokens
+	itemIndex  int
+	// removed is set once the token has been retracted.
+	removed bool
+}
+
+// itemTokens records the tokens which end with an item that a
+// RuleParameterNode holds, so that they can be found when it is
+// Retracted.
+type itemTokens struct {
+	tokens []*token
+}
+
+// Len returns the number of items in t.  The nil token is the empty
+// match.
+func (t *token) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.length
+}
+
+// items returns the items of t in parameter order.
+func (t *token) items() []interface{} {
+	items := make([]interface{}, t.Len())
+	for ; t != nil; t = t.parent {
+		items[t.length-1] = t.item
+	}
+	return items
+}
+
+// JoinTest restricts which items of a rule parameter are joined with
+// the matches of the parameters before it: only those whose Right key
+// is equal, by ==, to the Left key of the match.  The JoinNode indexes
+// both by key, so it only tries the pairs which pass.  Keys must be
+// comparable.
+type JoinTest struct {
+	// Left returns the key of a match of the preceding parameters.
+	Left func(parameters []interface{}) interface{}
+	// Right returns the key of an item of the parameter.
+	Right func(item interface{}) interface{}
+}
+
+// JoinTester is implemented by Rules whose parameters are joined with
+// JoinTests.
+type JoinTester interface {
+	// JoinTests returns a JoinTest, or nil, for each parameter of the
+	// rule after the first.
+	JoinTests() []*JoinTest
+}
+
+// WithJoinTests returns a Rule which is rule but for joining the
+// parameters after the first with tests.  A nil test joins every item.
+func WithJoinTests(rule Rule, tests ...*JoinTest) Rule {
+	return &joinTestRule{Rule: rule, tests: tests}
+}
+
+type joinTestRule struct {
+	Rule
+	tests []*JoinTest
+}
+
+// JoinTests is part of the JoinTester interface.
+func (r *joinTestRule) JoinTests() []*JoinTest {
+	return r.tests
+}
+
+// rightItem is an item of the right input of a JoinNode with a
+// JoinTest, and the record of the tokens which end with it.
+type rightItem struct {
+	item interface{}
+	it   *itemTokens
+}
+
+// JoinNode extends the tokens of its left input, the JoinNode for the
+// preceding parameters of a rule, with each item of its right input,
+// the RuleParameterNode for the next parameter.  The first JoinNode of
+// a rule has no left input and extends the empty match.
+//
+// A JoinNode remembers the tokens it has made, so each item only
+// costs the matches it takes part in.  Rules whose leading parameter
+// types are the same share JoinNodes.
+//
+// A JoinNode with a JoinTest only extends a token with the items whose
+// key matches its own.
+type JoinNode struct {
+	BasicNode
+	left  *JoinNode
+	right *RuleParameterNode
+	// depth is the number of items in the tokens of the node.
+	depth int
+	// first and last are the ends of the list of the node's tokens.
+	first, last *token
+	// test, if not nil, restricts what the node joins.  leftIndex and
+	// rightIndex then hold the tokens of the left input and the items
+	// of the right input by key.
+	test       *JoinTest
+	leftIndex  map[interface{}][]*token
+	rightIndex map[interface{}][]rightItem
+}
+
+// GetJoinNode finds or creates the JoinNode which joins the tokens of
+// left, or the empty match if left is nil, with the items of right that
+// pass test.  test is nil to join every item, and must be nil if left
+// is.  Only JoinNodes with the same JoinTest are shared.
+func GetJoinNode(left *JoinNode, right *RuleParameterNode, test *JoinTest) *JoinNode {
+	for _, o := range right.Outputs() {
+		if j, ok := o.(*JoinNode); ok && j.left == left && j.test == test {
+			return j
+		}
+	}
+	if left == nil && test != nil {
+		panic("the first JoinNode of a rule can't have a JoinTest")
+	}
+	j := &JoinNode{
+		left:  left,
+		right: right,
+		depth: 1,
+		test:  test,
+	}
+	if left != nil {
+		j.depth = left.depth + 1
+	}
+	// Start with the matches of what has already been asserted.
+	if test != nil {
+		j.leftIndex = map[interface{}][]*token{}
+		j.rightIndex = map[interface{}][]rightItem{}
+		for i, item := range right.items {
+			key := test.Right(item)
+			j.rightIndex[key] = append(j.rightIndex[key],
+				rightItem{item, right.itemTokens[i]})
+		}
+	}
+	j.doLeftTokens(func(t *token) {
+		j.joinLeft(t, func(item interface{}, it *itemTokens) {
+			j.add(t, item, it)
+		})
+	})
+	if left != nil {
+		Connect(left, j)
+	}
+	Connect(right, j)
+	return j
+}
+
+func (j *JoinNode) Label() string {
+	types := []string{}
+	for n := j; n != nil; n = n.left {
+		types = append([]string{n.right.Type().String()}, types...)
+	}
+	return fmt.Sprintf("join %s", strings.Join(types, ", "))
+}
+
+// Validate is part of the Node interface.
+func (j *JoinNode) Validate() []error {
+	errors := ValidateConnectivity(j)
+	inputs := 1
+	if j.left != nil {
+		inputs = 2
+		if j.depth != j.left.depth+1 {
+			errors = append(errors,
+				fmt.Errorf("%s has depth %d but its left input has depth %d",
+					j.Label(), j.depth, j.left.depth))
+		}
+	}
+	if j.left == nil && j.test != nil {
+		errors = append(errors,
+			fmt.Errorf("%s has a JoinTest but no left input", j.Label()))
+	}
+	if len(j.Inputs()) != inputs {
+		errors = append(errors,
+			fmt.Errorf("%s should have %d inputs", j.Label(), inputs))
+	}
+	return errors
+}
+
+// Clear is part of the Node interface.
+func (j *JoinNode) Clear() {
+	j.first = nil
+	j.last = nil
+	if j.test != nil {
+		j.leftIndex = map[interface{}][]*token{}
+		j.rightIndex = map[interface{}][]rightItem{}
+	}
+}
+
+// doLeftTokens applies f to the tokens of the left input that exist
+// when it is called.
+func (j *JoinNode) doLeftTokens(f func(*token)) {
+	if j.left == nil {
+		f(nil)
+		return
+	}
+	last := j.left.last
+	for t := j.left.first; t != nil; t = t.next {
+		if !t.removed {
+			f(t)
+		}
+		if t == last {
+			break
+		}
+	}
+}
+
+// joinLeft records t, a new token of the left input, and applies f to
+// the items of the right input that t can be extended with and the
+// records of the tokens which end with them.
+func (j *JoinNode) joinLeft(t *token, f func(interface{}, *itemTokens)) {
+	if j.test == nil {
+		items, records := j.right.items, j.right.itemTokens
+		for i, item := range items {
+			f(item, records[i])
+		}
+		return
+	}
+	key := j.test.Left(t.items())
+	j.leftIndex[key] = append(j.leftIndex[key], t)
+	// f might add to the index, so work from a copy.
+	matches := append([]rightItem(nil), j.rightIndex[key]...)
+	for _, m := range matches {
+		f(m.item, m.it)
+	}
+}
+
+// joinRight records item, a new item of the right input, and applies f
+// to the tokens of the left input that it can extend.
+func (j *JoinNode) joinRight(item interface{}, it *itemTokens, f func(*token)) {
+	if j.test == nil {
+		j.doLeftTokens(f)
+		return
+	}
+	key := j.test.Right(item)
+	j.rightIndex[key] = append(j.rightIndex[key], rightItem{item, it})
+	matches := append([]*token(nil), j.leftIndex[key]...)
+	for _, t := range matches {
+		if !t.removed {
+			f(t)
+		}
+	}
+}
+
+// unindexLeft and unindexRight remove a token of the left input and an
+// item of the right input from the indexes of j.
+
+func (j *JoinNode) unindexLeft(t *token) {
+	key := j.test.Left(t.items())
+	tokens := j.leftIndex[key]
+	for k, t1 := range tokens {
+		if t1 == t {
+			tokens = append(tokens[:k], tokens[k+1:]...)
+			break
+		}
+	}
+	if len(tokens) == 0 {
+		delete(j.leftIndex, key)
+	} else {
+		j.leftIndex[key] = tokens
+	}
+}
+
+func (j *JoinNode) indexRight(item interface{}, it *itemTokens) {
+	key := j.test.Right(item)
+	j.rightIndex[key] = append(j.rightIndex[key], rightItem{item, it})
+}
+
+func (j *JoinNode) unindexRight(item interface{}, it *itemTokens) {
+	key := j.test.Right(item)
+	items := j.rightIndex[key]
+	for k, ri := range items {
+		if ri.it == it {
+			items = append(items[:k], items[k+1:]...)
+			break
+		}
+	}
+	if len(items) == 0 {
+		delete(j.rightIndex, key)
+	} else {
+		j.rightIndex[key] = items
+	}
+}
+
+// doRuleNodes applies f to the RuleNodes which take the matches of j.
+func (j *JoinNode) doRuleNodes(f func(*RuleNode)) {
+	for _, o := range j.Outputs() {
+		switch o := o.(type) {
+		case *RuleNode:
+			f(o)
+		case *JoinNode:
+			o.doRuleNodes(f)
+		}
+	}
+}
+
+// add makes the token which extends parent with item and puts it in
+// the memory of j.
+func (j *JoinNode) add(parent *token, item interface{}, it *itemTokens) *token {
+	if DEBUG_FILL_AND_CALL_MARSHAL_HOOK != nil {
+		DEBUG_FILL_AND_CALL_MARSHAL_HOOK(parent.Len(), j.right,
+			append(parent.items(), item))
+	}
+	t := &token{
+		parent:     parent,
+		item:       item,
+		length:     parent.Len() + 1,
+		join:       j,
+		prev:       j.last,
+		itemTokens: it,
+		itemIndex:  len(it.tokens),
+	}
+	if j.last == nil {
+		j.first = t
+	} else {
+		j.last.next = t
+	}
+	j.last = t
+	if parent != nil {
+		t.siblingIndex = len(parent.children)
+		parent.children = append(parent.children, t)
+	}
+	it.tokens = append(it.tokens, t)
+	return t
+}
+
+// remove takes t out of the memory of j and of the records of its
+// parent and item, then retracts it.
+func (j *JoinNode) remove(t *token) {
+	t.removed = true
+	if t.prev == nil {
+		j.first = t.next
+	} else {
+		t.prev.next = t.next
+	}
+	if t.next == nil {
+		j.last = t.prev
+	} else {
+		t.next.prev = t.prev
+	}
+	if p := t.parent; p != nil && !p.removed {
+		last := p.children[len(p.children)-1]
+		p.children[t.siblingIndex] = last
+		last.siblingIndex = t.siblingIndex
+		p.children = p.children[:len(p.children)-1]
+	}
+	if it := t.itemTokens; it != nil {
+		last := it.tokens[len(it.tokens)-1]
+		it.tokens[t.itemIndex] = last
+		last.itemIndex = t.itemIndex
+		it.tokens = it.tokens[:len(it.tokens)-1]
+	}
+	j.EmitRetraction(t)
+}
+
+// Receive is part of the Node interface.  item is a token from the
+// left input, which is extended with each item of the right input.
+func (j *JoinNode) Receive(item interface{}) {
+	t := item.(*token)
+	j.joinLeft(t, func(right interface{}, it *itemTokens) {
+		j.Emit(j.add(t, right, it))
+	})
+}
+
+// receiveRight extends each token of the left input with item, which
+// the right input has just Received.
+func (j *JoinNode) receiveRight(item interface{}, it *itemTokens) {
+	j.joinRight(item, it, func(t *token) {
+		j.Emit(j.add(t, item, it))
+	})
+}
+
+// retractRight forgets item, which the right input has just Retracted.
+// The tokens which end with it are retracted by the right input.
+func (j *JoinNode) retractRight(item interface{}, it *itemTokens) {
+	if j.test != nil {
+		j.unindexRight(item, it)
+	}
+}
+
+// Retract is part of the Node interface.  item is a token from the
+// left input.  The tokens which extend it are retracted.
+func (j *JoinNode) Retract(item interface{}) {
+	t := item.(*token)
+	if j.test != nil {
+		j.unindexLeft(t)
+	}
+	for _, child := range t.children {
+		if child.join == j && !child.removed {
+			j.remove(child)
+		}
+	}
+}
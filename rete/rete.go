@@ -2,6 +2,8 @@
 package rete
 
 import "fmt"
+import "math"
+import "math/cmplx"
 import "reflect"
 
 
@@ -25,6 +27,14 @@ type Node interface {
 	// Receive causes the node to process an input item.
 	Receive(item interface{})
 
+	// EmitRetraction withdraws item from this node's Outputs.  It does
+	// so by calling Retract on each Output.
+	EmitRetraction(item interface{})
+
+	// Retract causes the node to withdraw an item that it Received
+	// earlier, along with anything it Emitted because of it.
+	Retract(item interface{})
+
 	// Validate returns a slice of errors if the Node doesn't pass
 	// validity checks.
 	Validate() []error
@@ -130,6 +140,26 @@ func (n *BasicNode) Receive(interface{}) {
 	panic(fmt.Sprintf("BasicNode.Receive on %T", n))
 }
 
+// DEBUG_EMIT_RETRACTION_HOOK, if not nil, is called by the
+// EmitRetraction method.
+var DEBUG_EMIT_RETRACTION_HOOK func(Node, interface{}) = nil
+
+// EmitRetraction is part of the node interface.
+func (n *BasicNode) EmitRetraction(item interface{}) {
+	if DEBUG_EMIT_RETRACTION_HOOK != nil {
+		DEBUG_EMIT_RETRACTION_HOOK(n, item)
+	}
+	for _, o := range n.Outputs() {
+		o.Retract(item)
+	}
+}
+
+// Retract is part of the node interface.  By default the retraction is
+// passed on to the outputs, as Receive passes items on.
+func (n *BasicNode) Retract(item interface{}) {
+	n.EmitRetraction(item)
+}
+
 func (n *BasicNode) Clear() {
 	// Default method.
 }
@@ -163,6 +193,13 @@ func (n *ActionNode) Receive(item interface{}) {
 	n.Emit(item)
 }
 
+// Retract is part of the Node interface.  The action is not undone, and
+// whatever consumed the results of the action, such as the facts it
+// asserted, is not told.  Only the outputs of the node see the retraction.
+func (n *ActionNode) Retract(item interface{}) {
+	n.EmitRetraction(item)
+}
+
 // Validate is part is part of the Node interface.
 func (n *ActionNode) Validate() []error {
 	return ValidateConnectivity(n)
@@ -187,6 +224,13 @@ func (n *TestNode) Receive(item interface{}) {
 	}
 }
 
+// Retract is part of the node interface.
+func (n *TestNode) Retract(item interface{}) {
+	if n.testFunction(item) {
+		n.EmitRetraction(item)
+	}
+}
+
 // Validate is part of the Node interface.
 func (n *TestNode) Validate() []error {
 	return ValidateConnectivity(n)
@@ -213,6 +257,13 @@ func (n *TypeFilterNode) Receive(item interface{}) {
 	}
 }
 
+// Retract is part of the node interface.
+func (n *TypeFilterNode) Retract(item interface{}) {
+	if reflect.TypeOf(item) == n.testType {
+		n.EmitRetraction(item)
+	}
+}
+
 // Validate is part of the Node interface.
 func (n *TypeFilterNode) Validate() []error {
 	return ValidateConnectivity(n)
@@ -235,10 +286,13 @@ func GetTypeFilterNode(n Node, t reflect.Type) *TypeFilterNode {
 
 
 // FunctionNode calls function on the incoming item.  It can
-// conditionally Emit that item or something else.
+// conditionally Emit that item or something else.  Retractions are
+// passed through unless retractFunction is set, in which case it is
+// called on them instead.
 type FunctionNode struct {
 	BasicNode
-	function func(Node, interface{})
+	function        func(Node, interface{})
+	retractFunction func(Node, interface{})
 }
 
 func MakeFunctionNode(label string, function func(Node, interface{})) *FunctionNode {
@@ -249,11 +303,27 @@ func MakeFunctionNode(label string, function func(Node, interface{})) *FunctionN
 	return n
 }
 
+// SetRetractFunction makes n call function on retracted items rather
+// than passing them through.  function should withdraw whatever the
+// node's function Emitted for the item.
+func (n *FunctionNode) SetRetractFunction(function func(Node, interface{})) {
+	n.retractFunction = function
+}
+
 // Receive is part of the node interface.
 func (n *FunctionNode) Receive(item interface{}) {
 	n.function(n, item)
 }
 
+// Retract is part of the node interface.
+func (n *FunctionNode) Retract(item interface{}) {
+	if n.retractFunction != nil {
+		n.retractFunction(n, item)
+		return
+	}
+	n.EmitRetraction(item)
+}
+
 // Validate is part of the Node interface.
 func (n *FunctionNode) Validate() []error {
 	return ValidateConnectivity(n)
@@ -298,6 +368,142 @@ func (n *BufferNode) Receive(item interface{}) {
 	n.Emit(item)
 }
 
+// Retract is part of the Node interface.  It removes one occurrence of
+// item from the buffer.
+func (n *BufferNode) Retract(item interface{}) {
+	var found bool
+	if n.items, found = removeItem(n.items, item); found {
+		n.EmitRetraction(item)
+	}
+}
+
+// removeItem removes the first occurrence of item from items.  The
+// second return value is false if items doesn't contain item.
+func removeItem(items []interface{}, item interface{}) ([]interface{}, bool) {
+	for i, it := range items {
+		if sameItem(it, item) {
+			return append(items[:i], items[i+1:]...), true
+		}
+	}
+	return items, false
+}
+
+// sameItem returns true if a and b are the same item: equal with == if
+// they can be map keys, and otherwise the same by sameValue.
+func sameItem(a, b interface{}) bool {
+	if keyable(reflect.ValueOf(a)) && keyable(reflect.ValueOf(b)) {
+		return a == b
+	}
+	return sameValue(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+// keyable returns true if v can be used as a map key and found again:
+// its type is comparable, the interface values in it don't hold values
+// that aren't, and it contains no NaN.  The zero Value, for a nil item,
+// can.
+func keyable(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	if !v.Type().Comparable() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return !math.IsNaN(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		return !cmplx.IsNaN(v.Complex())
+	case reflect.Interface:
+		return v.IsNil() || keyable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !keyable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !keyable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sameValue is like reflect.DeepEqual except that NaN is the same as
+// NaN, so that items containing NaN can be retracted, and pointers are
+// only the same as themselves, as with ==.
+func sameValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return sameFloat(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		x, y := a.Complex(), b.Complex()
+		return sameFloat(real(x), real(y)) && sameFloat(imag(x), imag(y))
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Interface:
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			bv := b.MapIndex(iter.Key())
+			if !bv.IsValid() || !sameValue(iter.Value(), bv) {
+				return false
+			}
+		}
+		return true
+	case reflect.Func:
+		return a.IsNil() && b.IsNil()
+	}
+	return a.Pointer() == b.Pointer()
+}
+
+func sameFloat(x, y float64) bool {
+	return x == y || math.IsNaN(x) && math.IsNaN(y)
+}
+
 func (n *BufferNode) Clear() {
 	n.items = nil
 }
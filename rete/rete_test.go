@@ -1,6 +1,7 @@
 package rete
 
 import "fmt"
+import "math"
 import "reflect"
 import "sort"
 import "testing"
@@ -121,3 +122,364 @@ func TestBufferClear (t *testing.T) {
 	}
 }
 
+
+// bufferContents returns the items of n that are ints, in order.
+func bufferContents(n AbstractBufferNode) []int {
+	got := []int{}
+	n.DoItems(func(item interface{}) {
+		got = append(got, item.(int))
+	})
+	sort.Ints(got)
+	return got
+}
+
+func TestBufferRetract(t *testing.T) {
+	n1 := MakeTestNode(func(item interface{}) bool { return true })
+	n2 := &BufferNode{}
+	Connect(n1, n2)
+	retracted := []interface{}{}
+	a := MakeFunctionNode("retractions", func(Node, interface{}) {})
+	a.SetRetractFunction(func(n Node, item interface{}) {
+		retracted = append(retracted, item)
+	})
+	Connect(n2, a)
+	n1.Receive(1)
+	n1.Receive(2)
+	n1.Receive(2)
+	n1.Retract(2)
+	n1.Retract(3)
+	if got := bufferContents(n2); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("BufferNode has %v after retraction, not [1 2]", got)
+	}
+	if !reflect.DeepEqual(retracted, []interface{}{2}) {
+		t.Errorf("BufferNode passed on retractions %v, not [2]", retracted)
+	}
+}
+
+// Items that can't be compared with == can be retracted from
+// BufferNodes.
+func TestBufferRetractUncomparable(t *testing.T) {
+	n := &BufferNode{}
+	n.Receive([]int{1})
+	n.Receive(map[string]int{"a": 1})
+	n.Receive(1)
+	n.Receive(math.NaN())
+	n.Retract([]int{1})
+	n.Retract(map[string]int{"a": 1})
+	n.Retract(math.NaN())
+	n.Retract([]int{2})
+	if want, got := 1, n.Count(); want != got {
+		t.Errorf("wrong count after retraction: want %d, got %d", want, got)
+	}
+}
+
+// passNode doesn't define Retract, so it passes retractions on.
+type passNode struct {
+	BasicNode
+}
+
+func TestBasicNodeRetract(t *testing.T) {
+	n1 := &passNode{}
+	retracted := []interface{}{}
+	a := MakeFunctionNode("retractions", func(Node, interface{}) {})
+	a.SetRetractFunction(func(n Node, item interface{}) {
+		retracted = append(retracted, item)
+	})
+	Connect(n1, a)
+	n1.Retract(1)
+	if !reflect.DeepEqual(retracted, []interface{}{1}) {
+		t.Errorf("BasicNode passed on retractions %v, not [1]", retracted)
+	}
+}
+
+func TestUniqueBufferRetract(t *testing.T) {
+	n1 := MakeTestNode(func(item interface{}) bool { return true })
+	n2 := GetUniqueBuffered(n1, func(a, b interface{}) bool {
+		return a.(int)%10 == b.(int)%10
+	})
+	n3 := GetBuffered(n2)
+	n1.Receive(1)
+	n1.Receive(11)
+	n1.Receive(2)
+	n1.Retract(1)
+	if got := bufferContents(n3); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("an item with remaining support was retracted: %v", got)
+	}
+	n1.Retract(21)
+	if got := bufferContents(n3); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("an item without support was not retracted: %v", got)
+	}
+	if got := bufferContents(n2); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("UniqueBufferNode has %v after retraction, not [2]", got)
+	}
+}
+
+type retractFact1 struct{ n int }
+type retractFact2 struct{ s string }
+type retractConclusion struct {
+	f1 *retractFact1
+	f2 *retractFact2
+}
+
+func TestRuleRetract(t *testing.T) {
+	root := MakeRootNode()
+	conclusionType := reflect.TypeOf(&retractConclusion{})
+	rule := AddRule("retract_test", "rule_retract_test",
+		nil,
+		func(node Node, parameters []interface{}) {
+			node.Emit(&retractConclusion{
+				f1: parameters[0].(*retractFact1),
+				f2: parameters[1].(*retractFact2),
+			})
+		},
+		[]reflect.Type{
+			reflect.TypeOf(&retractFact1{}),
+			reflect.TypeOf(&retractFact2{}),
+		},
+		[]reflect.Type{conclusionType})
+	InstallRule(root, rule)
+	conclusions := GetBuffered(GetTypeTestNode(root, conclusionType))
+	f1 := &retractFact1{1}
+	f2a := &retractFact2{"a"}
+	f2b := &retractFact2{"b"}
+	root.Receive(f1)
+	root.Receive(f2a)
+	root.Receive(f2b)
+	if want, got := 2, conclusions.Count(); want != got {
+		t.Errorf("wrong number of conclusions: want %d, got %d", want, got)
+	}
+	root.Retract(f2a)
+	if want, got := 1, conclusions.Count(); want != got {
+		t.Errorf("wrong number of conclusions after retracting a parameter: want %d, got %d", want, got)
+	}
+	conclusions.DoItems(func(item interface{}) {
+		if item.(*retractConclusion).f2 != f2b {
+			t.Errorf("the wrong conclusion was retracted")
+		}
+	})
+	root.Retract(f1)
+	if want, got := 0, conclusions.Count(); want != got {
+		t.Errorf("wrong number of conclusions after retracting a parameter: want %d, got %d", want, got)
+	}
+	root.Receive(f1)
+	if want, got := 1, conclusions.Count(); want != got {
+		t.Errorf("wrong number of conclusions after reasserting a parameter: want %d, got %d", want, got)
+	}
+}
+
+// callRecorder returns a rule Caller which records the parameters it is
+// called with in calls.
+func callRecorder(calls *[]string) func(Node, []interface{}) {
+	return func(node Node, parameters []interface{}) {
+		*calls = append(*calls, fmt.Sprint(parameters...))
+	}
+}
+
+// labelled can't be a map key if L holds a slice.
+type labelled struct {
+	L interface{}
+}
+
+// Items that can't be map keys, or can't be found again because they
+// contain NaN, can be retracted from RuleParameterNodes.
+func TestRuleParameterRetract(t *testing.T) {
+	root := MakeRootNode()
+	calls := []string{}
+	rule := AddRule("rule_parameter_retract", "rule_rule_parameter_retract",
+		nil, callRecorder(&calls),
+		[]reflect.Type{reflect.TypeOf(labelled{}), reflect.TypeOf("")},
+		[]reflect.Type{})
+	InstallRule(root, rule)
+	root.Receive(labelled{[]int{1}})
+	root.Receive(labelled{1})
+	root.Receive(labelled{[]int{2}})
+	root.Receive(labelled{1})
+	root.Receive(labelled{math.NaN()})
+	root.Receive(labelled{[]float64{math.NaN()}})
+	root.Retract(labelled{[]int{1}})
+	root.Retract(labelled{math.NaN()})
+	root.Retract(labelled{[]float64{math.NaN()}})
+	root.Retract(labelled{1})
+	root.Retract(labelled{3})
+	root.Receive("x")
+	sort.Strings(calls)
+	if want, got := "[{1}x {[2]}x]", fmt.Sprint(calls); want != got {
+		t.Errorf("wrong calls: want %v, got %v", want, got)
+	}
+	rpn := GetRuleParameterNode(GetTypeTestNode(root, reflect.TypeOf(labelled{})))
+	if want, got := 2, rpn.Count(); want != got {
+		t.Errorf("wrong number of items: want %d, got %d", want, got)
+	}
+}
+
+func TestJoinSameType(t *testing.T) {
+	root := MakeRootNode()
+	calls := []string{}
+	rule := AddRule("join_same_type", "rule_join_same_type",
+		nil, callRecorder(&calls),
+		[]reflect.Type{reflect.TypeOf(""), reflect.TypeOf("")},
+		[]reflect.Type{})
+	InstallRule(root, rule)
+	root.Receive("a")
+	root.Receive("b")
+	root.Receive("c")
+	sort.Strings(calls)
+	want := []string{"aa", "ab", "ac", "ba", "bb", "bc", "ca", "cb", "cc"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("wrong calls: want %v, got %v", want, calls)
+	}
+}
+
+func TestJoinSharing(t *testing.T) {
+	root := MakeRootNode()
+	calls1 := []string{}
+	calls2 := []string{}
+	rule1 := AddRule("join_sharing_1", "rule_join_sharing_1",
+		nil, callRecorder(&calls1),
+		[]reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0)},
+		[]reflect.Type{})
+	rule2 := AddRule("join_sharing_2", "rule_join_sharing_2",
+		nil, callRecorder(&calls2),
+		[]reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0), reflect.TypeOf(true)},
+		[]reflect.Type{})
+	InstallRule(root, rule1)
+	root.Receive("a")
+	root.Receive(1)
+	// rule2 is installed after some facts are asserted.  It isn't
+	// applied to them, but it is applied to the matches they make
+	// with later facts.
+	InstallRule(root, rule2)
+	root.Receive(true)
+	root.Receive(2)
+	joins := 0
+	Walk(root, func(n Node) {
+		if _, ok := n.(*JoinNode); ok {
+			joins++
+		}
+		for _, err := range n.Validate() {
+			t.Errorf("%s", err)
+		}
+	})
+	if want, got := 3, joins; want != got {
+		t.Errorf("wrong number of JoinNodes: want %d, got %d", want, got)
+	}
+	if want, got := "[a1 a2]", fmt.Sprint(calls1); want != got {
+		t.Errorf("wrong calls of rule1: want %v, got %v", want, got)
+	}
+	if want, got := "[a1 true a2 true]", fmt.Sprint(calls2); want != got {
+		t.Errorf("wrong calls of rule2: want %v, got %v", want, got)
+	}
+	root.Retract("a")
+	root.Receive("b")
+	if want, got := "[a1 a2 b1 b2]", fmt.Sprint(calls1); want != got {
+		t.Errorf("wrong calls of rule1 after retraction: want %v, got %v", want, got)
+	}
+	if want, got := "[a1 true a2 true b1 true b2 true]", fmt.Sprint(calls2); want != got {
+		t.Errorf("wrong calls of rule2 after retraction: want %v, got %v", want, got)
+	}
+}
+
+type person struct{ name string }
+type pet struct{ owner, name string }
+
+func (p *person) String() string { return p.name }
+func (p *pet) String() string    { return p.name }
+
+// TestJoinTest joins people with pairs of their pets.  The JoinNodes
+// look up the matches by owner rather than trying every pair.
+func TestJoinTest(t *testing.T) {
+	root := MakeRootNode()
+	keys := 0
+	owner := func(item interface{}) interface{} {
+		keys++
+		return item.(*pet).owner
+	}
+	name := func(parameters []interface{}) interface{} {
+		keys++
+		return parameters[0].(*person).name
+	}
+	calls := []string{}
+	rule := AddRule("join_test", "rule_join_test",
+		nil, callRecorder(&calls),
+		[]reflect.Type{reflect.TypeOf(&person{}), reflect.TypeOf(&pet{}), reflect.TypeOf(&pet{})},
+		[]reflect.Type{})
+	InstallRule(root, WithJoinTests(rule,
+		&JoinTest{Left: name, Right: owner},
+		&JoinTest{Left: name, Right: owner}))
+	Walk(root, func(n Node) {
+		for _, err := range n.Validate() {
+			t.Errorf("%s", err)
+		}
+	})
+	const people = 100
+	pets := []*pet{}
+	for i := 0; i < people; i++ {
+		root.Receive(&person{fmt.Sprint("p", i)})
+		pets = append(pets, &pet{fmt.Sprint("p", i), fmt.Sprint("a", i)})
+		root.Receive(pets[i])
+	}
+	root.Receive(&pet{"p1", "b1"})
+	var want []string
+	for i := 0; i < people; i++ {
+		names := []string{pets[i].name}
+		if i == 1 {
+			names = append(names, "b1")
+		}
+		for _, p1 := range names {
+			for _, p2 := range names {
+				want = append(want, fmt.Sprintf("p%d %s %s", i, p1, p2))
+			}
+		}
+	}
+	sort.Strings(calls)
+	sort.Strings(want)
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("wrong calls: want %v, got %v", want, calls)
+	}
+	// Each token and item has its key taken once by each JoinNode
+	// that indexes it.
+	if keys > 6*people {
+		t.Errorf("%d keys were taken for %d people", keys, people)
+	}
+	// A new pet of p1's is only joined with p1.
+	calls = nil
+	root.Retract(pets[1])
+	b2 := &pet{"p1", "b2"}
+	root.Receive(b2)
+	sort.Strings(calls)
+	if want, got := "[p1 b1 b2 p1 b2 b1 p1 b2 b2]", fmt.Sprint(calls); want != got {
+		t.Errorf("wrong calls after retraction: want %v, got %v", want, got)
+	}
+}
+
+// The fill_and_call hooks are still called.
+func TestFillAndCallHooks(t *testing.T) {
+	defer func() {
+		DEBUG_FILL_AND_CALL_ENTRY_HOOK = nil
+		DEBUG_FILL_AND_CALL_MARSHAL_HOOK = nil
+		DEBUG_FILL_AND_CALL_RULE_CALL_HOOK = nil
+	}()
+	hooks := []string{}
+	DEBUG_FILL_AND_CALL_ENTRY_HOOK = func(in *RuleParameterNode, item interface{}, rule_node *RuleNode) {
+		hooks = append(hooks, fmt.Sprint("entry ", item, " ", rule_node.Label()))
+	}
+	DEBUG_FILL_AND_CALL_MARSHAL_HOOK = func(position int, in *RuleParameterNode, parameters []interface{}) {
+		hooks = append(hooks, fmt.Sprint("marshal ", position, " ", parameters))
+	}
+	DEBUG_FILL_AND_CALL_RULE_CALL_HOOK = func(rule_node *RuleNode, parameters []interface{}) {
+		hooks = append(hooks, fmt.Sprint("call ", parameters))
+	}
+	root := MakeRootNode()
+	calls := []string{}
+	rule := AddRule("hooks", "rule_hooks",
+		nil, callRecorder(&calls),
+		[]reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0)},
+		[]reflect.Type{})
+	InstallRule(root, rule)
+	root.Receive("a")
+	root.Receive(1)
+	want := "[entry a rule hooks marshal 0 [a] entry 1 rule hooks marshal 1 [a 1] call [a 1]]"
+	if got := fmt.Sprint(hooks); want != got {
+		t.Errorf("wrong hook calls: want %v, got %v", want, got)
+	}
+}
@@ -2,25 +2,120 @@ package rete
 
 import "fmt"
 import "reflect"
+import "sort"
 
 
-// RuleNode implements the application of a rule.
+// RuleNode implements the application of a rule.  Its input is the
+// JoinNode for all of the rule's parameters, which sends it a token for
+// each combination of them.
 type RuleNode struct {
 	BasicNode
 	RuleSpec Rule
+	// derivations records what the rule Emitted for each token, so
+	// that it can be retracted if the token is.
+	derivations map[*token][]interface{}
+	// current is the token that the rule is being applied to.
+	current *token
+}
+
+// DEBUG_RULE_CALL_HOOK, if not nil, is called with a rule node and a
+// slice containing the parameters the rule is being applied to.
+var DEBUG_RULE_CALL_HOOK func(*RuleNode, []interface{}) = nil
+
+// DEBUG_FILL_AND_CALL_ENTRY_HOOK, if not nil, is called with a
+// RuleParameterNode, the item it is receiving and each RuleNode that
+// takes the item as a parameter.
+//
+// Deprecated: rules are no longer applied by fill_and_call.  Use
+// DEBUG_RULE_PARAMETER_RECEIVE_HOOK.
+var DEBUG_FILL_AND_CALL_ENTRY_HOOK func(*RuleParameterNode, interface{}, *RuleNode) = nil
+
+// DEBUG_FILL_AND_CALL_MARSHAL_HOOK, if not nil, is called as a JoinNode
+// extends a match with a parameter.  The arguments to the hook are the
+// parameter position, the RuleParameterNode providing the parameter at
+// that position, and a slice containing the parameters of the match.
+//
+// Deprecated: rules are no longer applied by fill_and_call.
+var DEBUG_FILL_AND_CALL_MARSHAL_HOOK func(int, *RuleParameterNode, []interface{}) = nil
+
+// DEBUG_FILL_AND_CALL_RULE_CALL_HOOK is called like
+// DEBUG_RULE_CALL_HOOK.
+//
+// Deprecated: use DEBUG_RULE_CALL_HOOK.
+var DEBUG_FILL_AND_CALL_RULE_CALL_HOOK func(*RuleNode, []interface{}) = nil
+
+// Receive is part of the Node interface.  item is a token with an item
+// for each parameter of the rule, which is applied to them.
+func (n *RuleNode) Receive(item interface{}) {
+	t := item.(*token)
+	parameters := t.items()
+	if DEBUG_RULE_CALL_HOOK != nil {
+		DEBUG_RULE_CALL_HOOK(n, parameters)
+	}
+	if DEBUG_FILL_AND_CALL_RULE_CALL_HOOK != nil {
+		DEBUG_FILL_AND_CALL_RULE_CALL_HOOK(n, parameters)
+	}
+	// What the rule Emits might be Received by this rule again, so
+	// save the current token.
+	previous := n.current
+	n.current = t
+	n.RuleSpec.Caller()(n, parameters)
+	n.current = previous
+}
+
+// Emit is part of the Node interface.  Rule functions call it to
+// assert their conclusions.
+func (n *RuleNode) Emit(item interface{}) {
+	if n.current != nil {
+		if n.derivations == nil {
+			n.derivations = map[*token][]interface{}{}
+		}
+		n.derivations[n.current] = append(n.derivations[n.current], item)
+	}
+	n.BasicNode.Emit(item)
+}
+
+// Retract is part of the Node interface.  item is a token the rule was
+// applied to.  What the rule Emitted for it is retracted.
+func (n *RuleNode) Retract(item interface{}) {
+	t := item.(*token)
+	emitted := n.derivations[t]
+	delete(n.derivations, t)
+	for _, e := range emitted {
+		n.EmitRetraction(e)
+	}
+}
+
+// Clear is part of the Node interface.
+func (n *RuleNode) Clear() {
+	n.derivations = nil
 }
 
 // Validate is part of the node interface.
 func (n *RuleNode) Validate() []error {
 	errors := ValidateConnectivity(n)
-	for i, input := range n.Inputs() {
-		if _, ok := input.(AbstractBufferNode); !ok {
-			errors = append(errors,
-				fmt.Errorf("input %s of %s is not an AbstractBufferNode",
-					input.Label(), n.Label()))
-		}
-		param_type := n.RuleSpec.ParamTypes()[i]
-		input_type := input.Inputs()[0].(*TypeTestNode).Type
+	param_types := n.RuleSpec.ParamTypes()
+	if len(param_types) == 0 {
+		return errors
+	}
+	if len(n.Inputs()) != 1 {
+		return append(errors,
+			fmt.Errorf("%s should have exactly one input", n.Label()))
+	}
+	join, ok := n.Inputs()[0].(*JoinNode)
+	if !ok {
+		return append(errors,
+			fmt.Errorf("input %s of %s is not a JoinNode",
+				n.Inputs()[0].Label(), n.Label()))
+	}
+	if join.depth != len(param_types) {
+		return append(errors,
+			fmt.Errorf("input %s of %s joins %d parameters, not %d",
+				join.Label(), n.Label(), join.depth, len(param_types)))
+	}
+	for j := join; j != nil; j = j.left {
+		param_type := param_types[j.depth-1]
+		input_type := j.right.Type()
 		if param_type != input_type {
 			errors = append(errors,
 				fmt.Errorf("input type %v does not match parameter type %v",
@@ -35,7 +130,8 @@ func (n *RuleNode) Label() string {
 }
 
 // InstallRule installs a RuleNode for rule in the rete identified by
-// root.
+// root.  If rule is a JoinTester its parameters are joined with its
+// JoinTests.
 //
 // InstallRule also creates a buffer node for each of the rules output
 // types.
@@ -43,7 +139,12 @@ func InstallRule(root Node, rule Rule) {
 	rule_node := &RuleNode {
 		RuleSpec: rule,
 	}
-	for _, param_type := range rule.ParamTypes() {
+	var tests []*JoinTest
+	if jt, ok := rule.(JoinTester); ok {
+		tests = jt.JoinTests()
+	}
+	var join *JoinNode
+	for i, param_type := range rule.ParamTypes() {
 		// *** Common code that i'm not bothering to abstract out
 		// because I plan to introduce a single node type that
 		// both filters by type and buffers.
@@ -55,7 +156,14 @@ func InstallRule(root Node, rule Rule) {
 		if rpn == nil {
 			panic("GetRuleParameterNode returned nil for %v")
 		}
-		Connect(rpn, rule_node)
+		var test *JoinTest
+		if i > 0 && i <= len(tests) {
+			test = tests[i-1]
+		}
+		join = GetJoinNode(join, rpn, test)
+	}
+	if join != nil {
+		Connect(join, rule_node)
 	}
 	Connect(rule_node, root)
 	for _, emit_type := range rule.EmitTypes() {
@@ -74,8 +182,39 @@ func InstallRule(root Node, rule Rule) {
 }
 
 
+// RuleParameterNode holds the items of a type that rules take as
+// parameters.  Its outputs are the JoinNodes for those parameters.
 type RuleParameterNode struct {
 	BufferNode
+	// itemTokens parallels items.  It records the tokens which end
+	// with each item.
+	itemTokens []*itemTokens
+	// positions holds the indexes in items of the items that can be
+	// map keys, as keyable says.  The indexes of the others are in
+	// unhashable.
+	positions  map[interface{}][]int
+	unhashable []int
+	// joins are the JoinNode outputs of the node, deepest first.
+	joins []*JoinNode
+}
+
+// AddOutput is part of the Node interface.
+func (node *RuleParameterNode) AddOutput(output Node) {
+	node.BufferNode.AddOutput(output)
+	if j, ok := output.(*JoinNode); ok {
+		node.joins = append(node.joins, j)
+		sort.SliceStable(node.joins, func(a, b int) bool {
+			return node.joins[a].depth > node.joins[b].depth
+		})
+	}
+}
+
+// Clear is part of the Node interface.
+func (node *RuleParameterNode) Clear() {
+	node.BufferNode.Clear()
+	node.itemTokens = nil
+	node.positions = nil
+	node.unhashable = nil
 }
 
 // Validate is part of the Node interface.
@@ -119,23 +258,6 @@ func GetRuleParameterNode(ttn *TypeTestNode) *RuleParameterNode {
 	return rpn
 }
 
-// DEBUG_FILL_AND_CALL_ENTRY_HOOK is meant to allow for debugging
-// output.  If non-nil, this function will be called whenever
-// fill_and_call is entered.
-var DEBUG_FILL_AND_CALL_ENTRY_HOOK func(*RuleParameterNode, interface{}, *RuleNode) = nil
-
-// DEBUG_FILL_AND_CALL_MARSHAL_HOOK, if noy nil, is called as each
-// parameter is marshalled prior to the invocation of a rule function.
-// The arguments to the hook are the parameter position, the
-// *RuleParameterNode to be providing the parameter at that position,
-// and a slice containing the parameters marshalled so far.
-var DEBUG_FILL_AND_CALL_MARSHAL_HOOK func(int, *RuleParameterNode, []interface{}) = nil
-
-// DEBUG_FILL_AND_CALL_RULE_CALL_HOOK, if not nil, is called with a
-// rule node and a slice containing the parameters the rule is being
-// applied to.
-var DEBUG_FILL_AND_CALL_RULE_CALL_HOOK func(*RuleNode, []interface{}) = nil
-
 // Indent returns a string of count repeated copies of s.
 func Indent(s string, count int) string {
 	out := ""
@@ -145,52 +267,6 @@ func Indent(s string, count int) string {
 	return out
 }
 
-func fill_and_call(in *RuleParameterNode, in_item interface{}, rule_node *RuleNode) {
-	if DEBUG_FILL_AND_CALL_ENTRY_HOOK != nil {
-		DEBUG_FILL_AND_CALL_ENTRY_HOOK(in, in_item, rule_node)
-	}
-	// The same RuleParameterNode might appear multiple times in
-	// the inputs of a RuleNode if the type represented by that
-	// RuleParameterNode appears in multiple argument positions
-	// in the rule function.
-	//
-	// Any combinations of input parameters to the rule that do not
-	// include in_item have already been considered as previous
-	// items have been Received.  We must now consider all
-	// parameter combinations where in_item appears as at least
-	// one parameter.
-	parameters := make([]interface{}, len(rule_node.Inputs()))
-	var f func(int, bool)
-	f = func (param_position int, includes_in bool) {
-		if DEBUG_FILL_AND_CALL_MARSHAL_HOOK != nil {
-			DEBUG_FILL_AND_CALL_MARSHAL_HOOK(param_position, in, parameters)
-		}
-		if param_position >= len(parameters) {
-			if includes_in {
-				if DEBUG_FILL_AND_CALL_RULE_CALL_HOOK != nil {
-					DEBUG_FILL_AND_CALL_RULE_CALL_HOOK(rule_node, parameters)
-				}
-				rule_node.RuleSpec.Caller()(rule_node, parameters)
-			}
-			return
-		}
-		// Because (*RuleParameterNode).Receive adds the new
-		// item to the buffer beforew fill_and_call is called,
-		// we dn't need any special consideration of in_item
-		// other than to avoid applying the rule redundantly
-		// to parameter combinations that were considered
-		// before in_item was assertred.
-		nth_input := rule_node.Inputs()[param_position]
-		nth_input.(AbstractBufferNode).DoItems(
-			func(item interface{}) {
-				parameters[param_position] = item
-				f(param_position + 1,
-					includes_in || item == in_item)
-			})
-	}
-	f(0, false)
-}
-
 // DEBUG_RULE_PARAMETER_RECEIVE_HOOK, if not nil, is called each time
 // (*RuleParameterNode).Receive is called.  The hook function is
 // passed the RuleParameterNode and the item it is receiving.
@@ -200,30 +276,134 @@ func (node *RuleParameterNode) Receive(item interface{}) {
 	if DEBUG_RULE_PARAMETER_RECEIVE_HOOK != nil {
 		DEBUG_RULE_PARAMETER_RECEIVE_HOOK(node, item)
 	}
+	if DEBUG_FILL_AND_CALL_ENTRY_HOOK != nil {
+		for _, j := range node.joins {
+			j.doRuleNodes(func(rule_node *RuleNode) {
+				DEBUG_FILL_AND_CALL_ENTRY_HOOK(node, item, rule_node)
+			})
+		}
+	}
+	it := &itemTokens{}
+	node.addPosition(item, len(node.items))
 	node.items = append(node.items, item)
-	// A single rule might have more than one parameter of a given
-	// type and thus might appear as more than one output of a
-	// given RuleParameterNode.  This is a consequence of the
-	// decision that a rule have one input per parameter, rather
-	// than one input per parameter type.
-	//
-	// Each rule should be considerred only once but a rule
-	// parameter should be considered (by fill_and_call) in all
-	// parameter positions of the corresponding type.
-	done := map[Node]bool{}
+	node.itemTokens = append(node.itemTokens, it)
+	// A rule with more than one parameter of the item's type has a
+	// JoinNode for each of them among the node's outputs.  Deeper
+	// JoinNodes go first so that they don't see the tokens that the
+	// shallower ones make from item, which already extend those
+	// tokens with item themselves.
+	for _, j := range node.joins {
+		j.receiveRight(item, it)
+	}
 	for _, output := range node.Outputs() {
-		if rule_node, ok := output.(*RuleNode); ok {
-			// Consider each RuleNode we output to only once:
-			if done[output] {
-				continue
-			}
-			done[output] = true
-			fill_and_call(node, item, rule_node)
-		} else {
-			// if node is not buffering for a RuleNode
-			// then delegate to that output:
+		if _, ok := output.(*JoinNode); !ok {
 			output.Receive(item)
 		}
 	}
 }
 
+
+// DEBUG_RULE_PARAMETER_RETRACT_HOOK, if not nil, is called each time
+// (*RuleParameterNode).Retract is called.  The hook function is
+// passed the RuleParameterNode and the item it is retracting.
+var DEBUG_RULE_PARAMETER_RETRACT_HOOK func(*RuleParameterNode, interface{}) = nil
+
+// Retract is part of the Node interface.  item is removed from the
+// buffer and the tokens which include it are retracted, along with
+// what rules concluded from them.
+func (node *RuleParameterNode) Retract(item interface{}) {
+	if DEBUG_RULE_PARAMETER_RETRACT_HOOK != nil {
+		DEBUG_RULE_PARAMETER_RETRACT_HOOK(node, item)
+	}
+	i := node.position(item)
+	if i < 0 {
+		return
+	}
+	it := node.itemTokens[i]
+	node.removePosition(item, i)
+	// Move the last item into the place of the one removed.
+	last := len(node.items) - 1
+	if i != last {
+		node.removePosition(node.items[last], last)
+		node.addPosition(node.items[last], i)
+		node.items[i] = node.items[last]
+		node.itemTokens[i] = node.itemTokens[last]
+	}
+	node.items[last] = nil
+	node.items = node.items[:last]
+	node.itemTokens[last] = nil
+	node.itemTokens = node.itemTokens[:last]
+	for _, j := range node.joins {
+		j.retractRight(item, it)
+	}
+	tokens := it.tokens
+	it.tokens = nil
+	for _, t := range tokens {
+		t.itemTokens = nil
+	}
+	for _, t := range tokens {
+		if !t.removed {
+			t.join.remove(t)
+		}
+	}
+	for _, output := range node.Outputs() {
+		if _, ok := output.(*JoinNode); !ok {
+			output.Retract(item)
+		}
+	}
+}
+
+// position returns the index in node.items of an occurrence of item, or
+// -1 if there is none.  Items that can't be map keys, such as slices,
+// are looked for with sameItem.
+func (node *RuleParameterNode) position(item interface{}) int {
+	if keyable(reflect.ValueOf(item)) {
+		indexes := node.positions[item]
+		if len(indexes) == 0 {
+			return -1
+		}
+		return indexes[len(indexes)-1]
+	}
+	for k := len(node.unhashable) - 1; k >= 0; k-- {
+		if i := node.unhashable[k]; sameItem(node.items[i], item) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addPosition records that item is at index i of node.items.
+func (node *RuleParameterNode) addPosition(item interface{}, i int) {
+	if !keyable(reflect.ValueOf(item)) {
+		node.unhashable = append(node.unhashable, i)
+		return
+	}
+	if node.positions == nil {
+		node.positions = map[interface{}][]int{}
+	}
+	node.positions[item] = append(node.positions[item], i)
+}
+
+// removePosition forgets that item is at index i of node.items.
+func (node *RuleParameterNode) removePosition(item interface{}, i int) {
+	if !keyable(reflect.ValueOf(item)) {
+		node.unhashable = removeIndex(node.unhashable, i)
+		return
+	}
+	indexes := removeIndex(node.positions[item], i)
+	if len(indexes) == 0 {
+		delete(node.positions, item)
+	} else {
+		node.positions[item] = indexes
+	}
+}
+
+// removeIndex removes i from indexes.
+func removeIndex(indexes []int, i int) []int {
+	for k, index := range indexes {
+		if index == i {
+			return append(indexes[:k], indexes[k+1:]...)
+		}
+	}
+	return indexes
+}
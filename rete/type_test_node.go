@@ -26,6 +26,13 @@ func (n *TypeTestNode) Receive(item interface{}) {
 	}
 }
 
+// Retract is part of the node interface.
+func (n *TypeTestNode) Retract(item interface{}) {
+	if reflect.TypeOf(item).AssignableTo(n.Type) {
+		n.EmitRetraction(item)
+	}
+}
+
 // Validate is part of the Node interface.
 func (n *TypeTestNode) Validate() []error {
 	return ValidateConnectivity(n)
@@ -8,6 +8,9 @@ import "fmt"
 type UniqueBufferNode struct {
 	BasicNode
 	items []interface{}
+	// support counts how many times each item, or items equivalent to
+	// it, have been Received and not Retracted.
+	support []int
 	// equivalence_function implements the uniqueness test for
 	// this node.
 	equivalence_function func(interface{}, interface{}) bool
@@ -33,18 +36,40 @@ func (n *UniqueBufferNode) DoItems(f func(interface{})) {
 
 func (n *UniqueBufferNode) Clear() {
 	n.items = nil
+	n.support = nil
 }
 
 func (n *UniqueBufferNode) Receive(item interface{}) {
-	for _, i := range n.items {
+	for j, i := range n.items {
 		if n.equivalence_function(i, item) {
+			n.support[j]++
 			return
 		}
 	}
 	n.items = append(n.items, item)
+	n.support = append(n.support, 1)
 	n.Emit(item)
 }
 
+// Retract is part of the Node interface.  The item equivalent to item
+// is only removed, and its retraction Emitted, once every item
+// equivalent to it that was Received has been Retracted.
+func (n *UniqueBufferNode) Retract(item interface{}) {
+	for j, i := range n.items {
+		if !n.equivalence_function(i, item) {
+			continue
+		}
+		n.support[j]--
+		if n.support[j] > 0 {
+			return
+		}
+		n.items = append(n.items[:j], n.items[j+1:]...)
+		n.support = append(n.support[:j], n.support[j+1:]...)
+		n.EmitRetraction(i)
+		return
+	}
+}
+
 // GetUniqueBuffered finds or creates a UniqueBufferNode which buffers
 // the output of n.
 func GetUniqueBuffered(n Node, equivalence_function func(interface{}, interface{}) bool) *UniqueBufferNode {
@@ -0,0 +1,323 @@
+package unification
+
+import "reflect"
+import "goshua/goshua"
+
+// resolver holds the state of a single call to resolve.
+type resolver struct {
+	bindings goshua.Bindings
+	// unbound collects the Variables that have no value.
+	unbound []goshua.Variable
+	seen    map[goshua.Variable]bool
+	// active holds the Variables whose values are being resolved so
+	// that a Variable bound to a term containing itself is left in
+	// place rather than expanded forever.
+	active map[goshua.Variable]bool
+	// pointers maps each pointer, map and slice that has been copied to
+	// its copy so that shared and cyclic structure is preserved.
+	pointers map[pointerKey]reflect.Value
+	// scans records what changes has found out about the pointers,
+	// maps and slices it has looked at.  stack, current and count are
+	// for finding the strongly connected components among them.
+	scans   map[pointerKey]*scanState
+	stack   []*scanState
+	current *scanState
+	count   int
+	// noted holds the pointers, maps and slices whose unbound
+	// Variables noteUnbound has noted.
+	noted map[pointerKey]bool
+}
+
+// pointerKey identifies a pointer, map or non-empty slice.
+type pointerKey struct {
+	t reflect.Type
+	p uintptr
+	// n is the length of a slice.
+	n int
+}
+
+// referenceKey returns the pointerKey of v if v is a non-nil pointer or
+// map or a non-empty slice.
+func referenceKey(v reflect.Value) (pointerKey, bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if !v.IsNil() {
+			return pointerKey{t: v.Type(), p: v.Pointer()}, true
+		}
+	case reflect.Slice:
+		if v.Len() > 0 {
+			return pointerKey{t: v.Type(), p: v.Pointer(), n: v.Len()}, true
+		}
+	}
+	return pointerKey{}, false
+}
+
+// scanState is what changes knows about a pointer, map or slice.
+type scanState struct {
+	index, low int
+	onStack    bool
+	changes    bool
+}
+
+func resolve(term interface{}, b goshua.Bindings) (interface{}, []goshua.Variable) {
+	r := &resolver{
+		bindings: b,
+		seen:     make(map[goshua.Variable]bool),
+		active:   make(map[goshua.Variable]bool),
+		pointers: make(map[pointerKey]reflect.Value),
+		scans:    make(map[pointerKey]*scanState),
+		noted:    make(map[pointerKey]bool),
+	}
+	return r.resolve(term), r.unbound
+}
+
+func init() {
+	goshua.Resolve = resolve
+}
+
+func (r *resolver) resolve(term interface{}) interface{} {
+	if term == nil {
+		return nil
+	}
+	if v, ok := term.(goshua.Variable); ok {
+		return r.resolveVariable(v)
+	}
+	if rt, ok := term.(goshua.Resolvable); ok {
+		return rt.Resolve(r.resolve)
+	}
+	v := reflect.ValueOf(term)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Ptr, reflect.Struct:
+		if !r.changes(term) {
+			// term is returned as it is rather than copied.
+			r.noteUnbound(term)
+			return term
+		}
+		return r.resolveValue(v).Interface()
+	}
+	return term
+}
+
+func (r *resolver) addUnbound(v goshua.Variable) {
+	if !r.seen[v] {
+		r.seen[v] = true
+		r.unbound = append(r.unbound, v)
+	}
+}
+
+func (r *resolver) resolveVariable(v goshua.Variable) interface{} {
+	if goshua.IsAny(v) {
+		return v
+	}
+	value, ok := r.bindings.Get(v)
+	if !ok || r.active[v] {
+		r.addUnbound(v)
+		return v
+	}
+	r.active[v] = true
+	defer delete(r.active, v)
+	return r.resolve(value)
+}
+
+// changes returns true if resolving term would change it: if it
+// contains a Variable with a value or a Resolvable.  The pointers, maps
+// and slices in term form a graph, which can have cycles.  Those in one
+// strongly connected component of it, found as in Tarjan's algorithm,
+// change if any of them does.
+func (r *resolver) changes(term interface{}) bool {
+	if term == nil {
+		return false
+	}
+	if v, ok := term.(goshua.Variable); ok {
+		if goshua.IsAny(v) {
+			return false
+		}
+		_, bound := r.bindings.Get(v)
+		return bound
+	}
+	if _, ok := term.(goshua.Resolvable); ok {
+		return true
+	}
+	v := reflect.ValueOf(term)
+	key, ok := referenceKey(v)
+	if !ok {
+		return r.contentsChange(v)
+	}
+	if s, ok := r.scans[key]; ok {
+		if s.onStack && s.index < r.current.low {
+			r.current.low = s.index
+		}
+		return s.changes
+	}
+	s := &scanState{index: r.count, low: r.count, onStack: true}
+	r.count++
+	r.scans[key] = s
+	r.stack = append(r.stack, s)
+	parent := r.current
+	r.current = s
+	s.changes = r.contentsChange(v)
+	r.current = parent
+	if parent != nil && s.low < parent.low {
+		parent.low = s.low
+	}
+	if s.low == s.index {
+		// s is the root of a strongly connected component.
+		i := len(r.stack) - 1
+		for r.stack[i] != s {
+			i--
+		}
+		component := r.stack[i:]
+		r.stack = r.stack[:i]
+		changes := false
+		for _, m := range component {
+			changes = changes || m.changes
+		}
+		for _, m := range component {
+			m.changes = changes
+			m.onStack = false
+		}
+	}
+	return s.changes
+}
+
+// contentsChange returns true if resolving any of the terms that v
+// contains would change them.
+func (r *resolver) contentsChange(v reflect.Value) bool {
+	changes := false
+	r.doContents(v, func(term interface{}) {
+		if r.changes(term) {
+			changes = true
+		}
+	})
+	return changes
+}
+
+// doContents applies f to each of the terms that v contains.
+func (r *resolver) doContents(v reflect.Value, f func(interface{})) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			f(v.Index(i).Interface())
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			f(iter.Key().Interface())
+			f(iter.Value().Interface())
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			f(v.Elem().Interface())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				f(v.Field(i).Interface())
+			}
+		}
+	}
+}
+
+// noteUnbound adds the Variables in term, which has none with a value,
+// to the unbound Variables.
+func (r *resolver) noteUnbound(term interface{}) {
+	if v, ok := term.(goshua.Variable); ok {
+		if !goshua.IsAny(v) {
+			r.addUnbound(v)
+		}
+		return
+	}
+	if term == nil {
+		return
+	}
+	v := reflect.ValueOf(term)
+	if key, ok := referenceKey(v); ok {
+		if r.noted[key] {
+			return
+		}
+		r.noted[key] = true
+	}
+	r.doContents(v, r.noteUnbound)
+}
+
+// set stores resolved in to.  If resolved can't be stored there, for
+// example because it is the value of a Variable in a field of type
+// goshua.Variable, original is stored instead.
+func set(to reflect.Value, resolved interface{}, original reflect.Value) {
+	if resolved == nil {
+		to.Set(reflect.Zero(to.Type()))
+		return
+	}
+	if rv := reflect.ValueOf(resolved); rv.Type().AssignableTo(to.Type()) {
+		to.Set(rv)
+		return
+	}
+	to.Set(original)
+}
+
+// resolveValue returns a copy of v, an aggregate or pointer, with its
+// terms resolved.
+func (r *resolver) resolveValue(v reflect.Value) reflect.Value {
+	key, isReference := referenceKey(v)
+	if isReference {
+		if copy, ok := r.pointers[key]; ok {
+			return copy
+		}
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if !isReference {
+			return v
+		}
+		copy := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		r.pointers[key] = copy
+		for i := 0; i < v.Len(); i++ {
+			set(copy.Index(i), r.resolve(v.Index(i).Interface()), v.Index(i))
+		}
+		return copy
+	case reflect.Array:
+		copy := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			set(copy.Index(i), r.resolve(v.Index(i).Interface()), v.Index(i))
+		}
+		return copy
+	case reflect.Map:
+		if !isReference {
+			return v
+		}
+		copy := reflect.MakeMapWithSize(v.Type(), v.Len())
+		r.pointers[key] = copy
+		iter := v.MapRange()
+		for iter.Next() {
+			key := reflect.New(v.Type().Key()).Elem()
+			set(key, r.resolve(iter.Key().Interface()), iter.Key())
+			value := reflect.New(v.Type().Elem()).Elem()
+			set(value, r.resolve(iter.Value().Interface()), iter.Value())
+			copy.SetMapIndex(key, value)
+		}
+		return copy
+	case reflect.Ptr:
+		if !isReference {
+			return v
+		}
+		copy := reflect.New(v.Type().Elem())
+		r.pointers[key] = copy
+		set(copy.Elem(), r.resolve(v.Elem().Interface()), v.Elem())
+		return copy
+	case reflect.Struct:
+		// Copying the whole struct first takes care of unexported
+		// fields, which can't be set individually.
+		copy := reflect.New(v.Type()).Elem()
+		copy.Set(v)
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			set(copy.Field(i), r.resolve(v.Field(i).Interface()), v.Field(i))
+		}
+		return copy
+	}
+	return v
+}
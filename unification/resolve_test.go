@@ -0,0 +1,117 @@
+package unification
+
+import "reflect"
+import "testing"
+import "goshua/goshua"
+
+type resolveTestStruct struct {
+	A    interface{}
+	B    []interface{}
+	Next *resolveTestStruct
+	V    goshua.Variable
+	c    interface{}
+}
+
+func TestResolve(t *testing.T) {
+	s := goshua.NewScope()
+	x := s.Lookup("x")
+	y := s.Lookup("y")
+	z := s.Lookup("z")
+	u := s.Lookup("u")
+	b := goshua.EmptyBindings()
+	bind := func(v goshua.Variable, val interface{}) {
+		var ok bool
+		if b, ok = b.Bind(v, val); !ok {
+			t.Fatalf("binding %s to %v failed", v.Name(), val)
+		}
+	}
+	bind(x, 1)
+	bind(y, z)
+	bind(z, []interface{}{x, "two"})
+
+	term := &resolveTestStruct{
+		A: y,
+		B: []interface{}{x, u, goshua.Any},
+		Next: &resolveTestStruct{
+			A: map[string]interface{}{"x": x},
+		},
+		V: x,
+		c: x,
+	}
+	got, unbound := goshua.Resolve(term, b)
+	want := &resolveTestStruct{
+		A: []interface{}{1, "two"},
+		B: []interface{}{1, u, goshua.Any},
+		Next: &resolveTestStruct{
+			A: map[string]interface{}{"x": 1},
+		},
+		// A goshua.Variable field can't hold 1.
+		V: x,
+		// Unexported fields are copied as is.
+		c: x,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve: got %#v, want %#v", got, want)
+	}
+	if len(unbound) != 1 || unbound[0] != u {
+		t.Errorf("Resolve: unbound should be [?u], got %v", unbound)
+	}
+	// The original term is unchanged.
+	if term.A != y || term.B[0] != x {
+		t.Errorf("Resolve modified its argument: %#v", term)
+	}
+}
+
+func TestResolveCycles(t *testing.T) {
+	s := goshua.NewScope()
+	x := s.Lookup("x")
+	b, _ := goshua.EmptyBindings().Bind(x, []interface{}{x})
+	got, unbound := goshua.Resolve(x, b)
+	if !reflect.DeepEqual(got, []interface{}{x}) {
+		t.Errorf("Resolve of self-referential variable: got %#v", got)
+	}
+	if len(unbound) != 1 || unbound[0] != x {
+		t.Errorf("Resolve: unbound should be [?x], got %v", unbound)
+	}
+
+	ring := &resolveTestStruct{A: x}
+	ring.Next = ring
+	b, _ = goshua.EmptyBindings().Bind(x, 5)
+	got, _ = goshua.Resolve(ring, b)
+	r := got.(*resolveTestStruct)
+	if r == ring || r.Next != r || r.A != 5 {
+		t.Errorf("Resolve of cyclic structure: got %#v", r)
+	}
+}
+
+// Parts of a term without Variables that have values aren't copied.
+func TestResolveShares(t *testing.T) {
+	s := goshua.NewScope()
+	x := s.Lookup("x")
+	u := s.Lookup("u")
+	fact := &resolveTestStruct{A: "fact", B: []interface{}{u}}
+	b, _ := goshua.EmptyBindings().Bind(x, fact)
+	got, unbound := goshua.Resolve(x, b)
+	if got != fact {
+		t.Errorf("Resolve copied the value of x: %#v", got)
+	}
+	if len(unbound) != 1 || unbound[0] != u {
+		t.Errorf("Resolve: unbound should be [?u], got %v", unbound)
+	}
+	term := []interface{}{x, fact}
+	got, _ = goshua.Resolve(term, b)
+	if l := got.([]interface{}); l[0] != fact || l[1] != fact {
+		t.Errorf("Resolve copied a pointer without variables: %#v", l)
+	}
+	ring := &resolveTestStruct{A: u}
+	ring.Next = &resolveTestStruct{Next: ring}
+	if got, _ := goshua.Resolve(ring, b); got != ring {
+		t.Errorf("Resolve copied a cycle without variables")
+	}
+	list := []interface{}{x, nil}
+	list[1] = list
+	got, _ = goshua.Resolve(list, b)
+	if l := got.([]interface{}); l[0] != fact || reflect.ValueOf(l[1]).Pointer() != reflect.ValueOf(l).Pointer() {
+		t.Errorf("Resolve of cyclic slice: got %#v", l)
+	}
+}
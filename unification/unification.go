@@ -3,10 +3,29 @@ package unification
 
 import "log"
 import "reflect"
+import "goshua/equality"
 import "goshua/goshua"
 
 func unify(thing1, thing2 interface{}, b goshua.Bindings,
 	continuation func(goshua.Bindings)) {
+	if t, ok := b.(goshua.Trail); ok {
+		// This is a choice point.  If the unification fails, undo
+		// whatever it bound before failing so the next alternative
+		// starts from the store that b has.  After a success the
+		// bindings are left for the Bindings passed to continuation.
+		cp := t.Mark()
+		succeeded := false
+		next := continuation
+		continuation = func(b goshua.Bindings) {
+			succeeded = true
+			next(b)
+		}
+		defer func() {
+			if !succeeded {
+				t.Undo(cp)
+			}
+		}()
+	}
 	// Variable implements Unifier
 	if thing1, ok := thing1.(goshua.Unifier); ok {
 		thing1.Unify(thing2, b, continuation)
@@ -16,6 +35,11 @@ func unify(thing1, thing2 interface{}, b goshua.Bindings,
 		thing2.Unify(thing1, b, continuation)
 		return
 	}
+	// Values whose types decide their own equality aren't taken apart.
+	if equality.OwnEquality(thing1, thing2) {
+		(&equalOrFail{}).unify(thing1, thing2, b, continuation)
+		return
+	}
 
 	for _, u := range typeUnifiers {
 		if u.test(thing1) && u.test(thing2) {
@@ -60,9 +84,9 @@ type equalOrFail struct{}
 func (u *equalOrFail) unify(thing1, thing2 interface{},
 	b goshua.Bindings,
 	continuation func(goshua.Bindings)) {
-	eq, err := goshua.Equal(thing1, thing2)
+	eq, err := goshua.EqualIn(b, thing1, thing2)
 	if err != nil {
-		log.Printf("%s", err.Error())
+		log.Printf("%s", goshua.Explain(thing1, thing2, err).Error())
 		return
 	}
 	if eq {
@@ -113,19 +137,23 @@ func collectionUnifier(lengthFunction func(reflect.Value) int,
 			return
 		}
 
-		for i := 0; i < lengthFunction(v1); i++ {
-			fail := true
+		// Each element is unified in the continuation of the previous
+		// one so that the Bindings for the earlier elements are still
+		// in effect when the last continuation is called.
+		length := lengthFunction(v1)
+		var unifyFrom func(int, goshua.Bindings)
+		unifyFrom = func(i int, b goshua.Bindings) {
+			if i >= length {
+				continuation(b)
+				return
+			}
 			goshua.Unify(indexFunction(v1, i).Interface(),
 				indexFunction(v2, i).Interface(), b,
 				func(b1 goshua.Bindings) {
-					b = b1
-					fail = false
+					unifyFrom(i+1, b1)
 				})
-			if fail {
-				return
-			}
 		}
-		continuation(b)
+		unifyFrom(0, b)
 	}
 }
 
@@ -148,7 +176,13 @@ func (u sequenceUnifier) unify(thing1, thing2 interface{},
 		thing1, thing2, b, continuation)
 }
 
-type structUnifier struct{}
+// structUnifier unifies structs of the same type field by field.
+// Structs with unexported fields, like time.Time, can't be taken apart
+// that way, so they unify only if they are equal, which is an error unless
+// their type decides its own equality.
+type structUnifier struct {
+	equalOrFail
+}
 
 func (u *structUnifier) test(thing interface{}) bool {
 	return reflect.ValueOf(thing).Kind() == reflect.Struct
@@ -157,6 +191,16 @@ func (u *structUnifier) test(thing interface{}) bool {
 func (u *structUnifier) unify(thing1, thing2 interface{},
 	b goshua.Bindings,
 	continuation func(goshua.Bindings)) {
-	collectionUnifier(reflect.Value.NumField, reflect.Value.Index)(
+	t := reflect.TypeOf(thing1)
+	if t != reflect.TypeOf(thing2) {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			u.equalOrFail.unify(thing1, thing2, b, continuation)
+			return
+		}
+	}
+	collectionUnifier(reflect.Value.NumField, reflect.Value.Field)(
 		thing1, thing2, b, continuation)
 }
@@ -1,6 +1,11 @@
 package unification
 
+import "bytes"
+import "log"
+import "os"
+import "strings"
 import "testing"
+import "time"
 import "goshua/goshua"
 import _ "goshua/variables"
 import _ "goshua/bindings"
@@ -163,6 +168,72 @@ func TestTwoUnequalVariables(t *testing.T) {
 	}
 }
 
+// The anonymous variable unifies with anything without binding.
+func TestAnonymousVariable(t *testing.T) {
+	tc := MakeTestContinuation(t)
+	b := goshua.EmptyBindings()
+	goshua.Unify(goshua.Any, 5, b, tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("goshua.Any should unify with 5")
+	}
+	if tc.Bindings() != b {
+		t.Errorf("Unifying goshua.Any should not add bindings")
+	}
+	tc = MakeTestContinuation(t)
+	goshua.Unify(goshua.NewScope().Lookup("v"), goshua.Any, b, tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("A variable should unify with goshua.Any")
+	}
+	if tc.Bindings() != b {
+		t.Errorf("Unifying with goshua.Any should not add bindings")
+	}
+}
+
+// Each occurrence of the anonymous variable is independent.
+func TestAnonymousVariableInSlice(t *testing.T) {
+	s := goshua.NewScope()
+	v := s.Lookup("v")
+	tc := MakeTestContinuation(t)
+	goshua.Unify([]interface{}{goshua.Any, v, goshua.Any},
+		[]interface{}{1, 2, "three"},
+		goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Unification with goshua.Any in slice failed")
+	}
+	if val, ok := tc.Bindings().Get(v); !ok {
+		t.Errorf("v should be bound")
+	} else if eq, err := goshua.Equal(val, 2); err != nil || !eq {
+		t.Errorf("v should be bound to 2, not %v %v", val, err)
+	}
+}
+
+type testPair struct {
+	A interface{}
+	B interface{}
+}
+
+func TestAnonymousVariableInStruct(t *testing.T) {
+	s := goshua.NewScope()
+	v := s.Lookup("v")
+	tc := MakeTestContinuation(t)
+	goshua.Unify(testPair{goshua.Any, v}, testPair{"a", "b"},
+		goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Fatalf("Unification with goshua.Any in struct failed")
+	}
+	if val, ok := tc.Bindings().Get(v); !ok {
+		t.Errorf("v should be bound")
+	} else if eq, err := goshua.Equal(val, "b"); err != nil || !eq {
+		t.Errorf("v should be bound to \"b\", not %v %v", val, err)
+	}
+	tc = MakeTestContinuation(t)
+	goshua.Unify(testPair{goshua.Any, "x"}, testPair{"a", "b"},
+		goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("Structs with unequal fields should not unify")
+	}
+}
+
 // unify similar array and slice
 
 // fail to unify different array and slice
@@ -170,6 +241,69 @@ func TestTwoUnequalVariables(t *testing.T) {
 // unify similar structures
 
 // fail to unify different structures
+func TestUnifyStructsOfDifferentTypes(t *testing.T) {
+	type otherPair struct {
+		A interface{}
+		B interface{}
+	}
+	tc := MakeTestContinuation(t)
+	goshua.Unify(testPair{1, 2}, otherPair{1, 2},
+		goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("Structs of different types should not unify")
+	}
+}
+
+// Structs with unexported fields unify if they are equal.
+func TestUnifyUnexportedFields(t *testing.T) {
+	now := time.Now()
+	tc := MakeTestContinuation(t)
+	goshua.Unify(now, now.UTC(), goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Errorf("Equal times should unify")
+	}
+	tc = MakeTestContinuation(t)
+	goshua.Unify(now, now.Add(time.Second), goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("Different times should not unify")
+	}
+}
+
+// caseless decides its own equality, so it isn't unified field by field.
+type caseless struct {
+	S string
+}
+
+func (c caseless) GoshuaEqual(other interface{}) (bool, error) {
+	o, ok := other.(caseless)
+	return ok && strings.EqualFold(c.S, o.S), nil
+}
+
+type hidden struct {
+	n int
+}
+
+// Values whose types decide their own equality unify if they are equal.
+// Other structs with unexported fields can't be compared, so they don't
+// unify, and the unifier doesn't panic on them.
+func TestUnifyOwnEquality(t *testing.T) {
+	tc := MakeTestContinuation(t)
+	goshua.Unify(caseless{"Goshua"}, caseless{"gOSHUA"}, goshua.EmptyBindings(), tc.Continuation)
+	if !tc.WasContinued() {
+		t.Errorf("caseless values that are equal should unify")
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	tc = MakeTestContinuation(t)
+	goshua.Unify(hidden{1}, hidden{1}, goshua.EmptyBindings(), tc.Continuation)
+	if tc.WasContinued() {
+		t.Errorf("structs with unexported fields shouldn't unify")
+	}
+	if buf.Len() == 0 {
+		t.Errorf("the error comparing structs with unexported fields wasn't logged")
+	}
+}
 
 // Confirm variable getting bound to struct.
 
@@ -177,3 +311,24 @@ func TestTwoUnequalVariables(t *testing.T) {
 //   func (v Value) MapIndex(key Value) Value
 //   func (v Value) MapKeys() []Value
 // are map keys subject to unification, or just the associated values?
+
+// Unification failures which are errors are logged with where in the
+// values the error is.
+func TestUnifyErrorLogged(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	v := goshua.NewScope().Lookup("v")
+	a := []interface{}{1, map[string]interface{}{"f": func() {}}}
+	b := []interface{}{1, map[string]interface{}{"f": func() {}}}
+	tc := MakeTestContinuation(t)
+	goshua.Unify(v, a, goshua.EmptyBindings(), func(b1 goshua.Bindings) {
+		goshua.Unify(v, b, b1, tc.Continuation)
+	})
+	if tc.WasContinued() {
+		t.Errorf("values that can't be compared shouldn't unify")
+	}
+	if !strings.Contains(buf.String(), `at [1]["f"]`) {
+		t.Errorf("log %q doesn't say where the error is", buf.String())
+	}
+}
@@ -4,30 +4,65 @@
 // different logic variables.
 package variables
 
+import "crypto/rand"
+import "encoding/hex"
+import "encoding/json"
 import "fmt"
 import "log"
+import "sync"
 import "goshua/goshua"
 
 // *scope implements the goshua.Scope interface.
+// A scope can be shared between goroutines.
 type scope struct {
-	id    uint64
+	// id is assigned when it is first needed.
+	id    string
+	mutex sync.Mutex
 	index map[string]goshua.Variable
 }
 
 func newScope() goshua.Scope {
 	s := &scope{index: make(map[string]goshua.Variable)}
-	// TODO: set id to a unique value
 	return s
 }
 
+func namedScope(id string) goshua.Scope {
+	return &scope{
+		id:    id,
+		index: make(map[string]goshua.Variable),
+	}
+}
+
+func (s *scope) ID() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.id == "" {
+		// 128 random bits won't collide with the id of a scope
+		// from another process.
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", fmt.Errorf("variables: can't make a Scope ID: %s", err)
+		}
+		s.id = hex.EncodeToString(b[:])
+	}
+	return s.id, nil
+}
+
 // Compile time check that we're implementing goshua.Scope.
 var _ goshua.Scope = newScope()
 
 func init() {
 	goshua.NewScope = newScope
+	goshua.NamedScope = namedScope
+	goshua.Any = &anonymous{}
 }
 
 func (s *scope) Lookup(name string) goshua.Variable {
+	if name == anonymousName {
+		return goshua.Any
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if v, ok := s.index[name]; ok {
 		return v
 	}
@@ -49,6 +84,12 @@ func (v *variable) String() string {
 	return fmt.Sprintf("?%s", v.Name())
 }
 
+// MarshalJSON encodes a variable as a string like "?x" so that terms
+// with unbound variables can be encoded.
+func (v *variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
 // ^variable satisfies the goshua.Variable interface.
 func (v *variable) IsLogicVariable() {}
 
@@ -56,6 +97,10 @@ func (v *variable) Name() string {
 	return v.name
 }
 
+func (v *variable) Scope() goshua.Scope {
+	return v.scope
+}
+
 func (v *variable) SameAs(other goshua.Variable) bool {
 	return v == other
 }
@@ -67,16 +112,65 @@ func (v *variable) HasVariables() bool {
 func (v *variable) Unify(other interface{},
 	bindings goshua.Bindings,
 	continuation func(goshua.Bindings)) {
+	if goshua.IsAny(other) {
+		continuation(bindings)
+		return
+	}
 	if b, ok := bindings.Bind(v, other); ok {
 		// val, has := b.Get(v)
 		// log.Printf("bound %s to %#v: %v %#v", v.Name(), other, has, val)
 		// b.Dump()
 		continuation(b)
 	} else {
-		existing, _ := b.Get(v)
-		log.Printf("variable.Unify: Bind failed, %s value is %v", v, existing)
+		existing, _ := bindings.Get(v)
+		if _, diff := goshua.EqualDetail(existing, other); diff != nil {
+			log.Printf("variable.Unify: Bind of %s failed: %s", v, diff.Error())
+		} else {
+			log.Printf("variable.Unify: Bind failed, %s value is %v", v, existing)
+		}
 	}
 }
 
 // Compile time check that *variable implements goshua.Variable.
 var _ goshua.Variable = newScope().Lookup("a")
+
+// anonymousName is the name that Lookup maps to goshua.Any.
+const anonymousName = "_"
+
+// *anonymous implements goshua.Any, the anonymous wildcard variable.
+// It belongs to no scope.
+type anonymous struct{}
+
+func (v *anonymous) String() string {
+	return "?" + anonymousName
+}
+
+func (v *anonymous) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+func (v *anonymous) IsLogicVariable() {}
+
+func (v *anonymous) Name() string {
+	return anonymousName
+}
+
+func (v *anonymous) Scope() goshua.Scope {
+	return nil
+}
+
+// SameAs is always false since each occurrence of the anonymous variable
+// is independent of every other.
+func (v *anonymous) SameAs(other goshua.Variable) bool {
+	return false
+}
+
+// Unify succeeds without binding anything.
+func (v *anonymous) Unify(other interface{},
+	bindings goshua.Bindings,
+	continuation func(goshua.Bindings)) {
+	continuation(bindings)
+}
+
+// Compile time check that *anonymous implements goshua.Variable.
+var _ goshua.Variable = &anonymous{}
@@ -1,5 +1,6 @@
 package variables
 
+import "sync"
 import "testing"
 import "goshua/goshua"
 
@@ -47,3 +48,76 @@ func TestVariableDistinctness(t *testing.T) {
 		t.Errorf("Variables with same name but in different scopes should be different")
 	}
 }
+
+func TestAnonymousVariable(t *testing.T) {
+	scope := goshua.NewScope()
+	a := scope.Lookup("_")
+	if !goshua.IsAny(a) {
+		t.Errorf("Lookup(\"_\") should return goshua.Any")
+	}
+	if !goshua.IsAny(goshua.NewScope().Lookup("_")) {
+		t.Errorf("Every scope should return goshua.Any for \"_\"")
+	}
+	if a.SameAs(goshua.Any) {
+		t.Errorf("Occurrences of the anonymous variable should be independent")
+	}
+	if goshua.IsAny(scope.Lookup("a")) {
+		t.Errorf("a is not the anonymous variable")
+	}
+}
+
+// Run with -race to check that concurrent Lookups are safe.
+func TestConcurrentLookup(t *testing.T) {
+	scope := goshua.NewScope()
+	names := []string{"a", "b", "c", "d"}
+	const goroutines = 16
+	found := make([][]goshua.Variable, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for _, name := range names {
+					found[g] = append(found[g], scope.Lookup(name))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for _, name := range names {
+		want := scope.Lookup(name)
+		for g := range found {
+			for _, v := range found[g] {
+				if v.Name() == name && !v.SameAs(want) {
+					t.Errorf("concurrent Lookup(%q) returned different Variables", name)
+				}
+			}
+		}
+	}
+}
+
+func TestScopeID(t *testing.T) {
+	s1 := goshua.NewScope()
+	s2 := goshua.NewScope()
+	id1, err := s1.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2, _ := s2.ID(); id1 == id2 {
+		t.Errorf("Scopes should have different IDs")
+	}
+	if id, _ := s1.ID(); id != id1 {
+		t.Errorf("A Scope's ID should not change")
+	}
+	n := goshua.NamedScope("TestScopeID")
+	if id, _ := n.ID(); id != "TestScopeID" {
+		t.Errorf("NamedScope made a Scope with ID %q", id)
+	}
+	if goshua.NamedScope("TestScopeID") == n {
+		t.Errorf("NamedScope should make a new Scope each time")
+	}
+	if n.Lookup("a").Scope() != n {
+		t.Errorf("Variable has the wrong Scope")
+	}
+}